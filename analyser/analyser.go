@@ -3,7 +3,7 @@ package analyser
 import (
 	"github.com/motzel/go-bsor/bsor"
 	"math"
-	"replayAnalyzer/utils"
+	"playerAnalyzer/utils"
 )
 
 func AverageSwingDistance(frames []*bsor.PositionAndRotation) float64 {
@@ -8,6 +8,50 @@ var runner *acmd.Runner
 
 func createRunner() (runner *acmd.Runner) {
 	runner = acmd.RunnerOf([]acmd.Command{
+		{
+			Name:        "cache",
+			Description: "Inspects or cleans the response cache",
+			Subcommands: []acmd.Command{
+				{
+					Name:        "info",
+					Description: "Shows the amount and size of cached responses per host",
+					ExecFunc:    handleCacheInfoCmd,
+					FlagSet:     &cacheFlags{},
+				},
+				{
+					Name:        "prune",
+					Description: "Removes expired responses from the cache",
+					ExecFunc:    handleCachePruneCmd,
+					FlagSet:     &cacheFlags{},
+				},
+				{
+					Name:        "clear",
+					Description: "Removes all responses from the cache",
+					ExecFunc:    handleCacheClearCmd,
+				},
+			},
+		},
+		{
+			Name:        "fetch",
+			Alias:       "f",
+			Description: "Fetches the provided players scores and stores them as dataset",
+			ExecFunc:    handleFetchCmd,
+			FlagSet:     &fetchFlags{},
+		},
+		{
+			Name:        "sync",
+			Alias:       "s",
+			Description: "Pulls the provided players new scores into the local store",
+			ExecFunc:    handleSyncCmd,
+			FlagSet:     &syncFlags{},
+		},
+		{
+			Name:        "export",
+			Alias:       "e",
+			Description: "Exports the provided players plays as csv or columnar file",
+			ExecFunc:    handleExportCmd,
+			FlagSet:     &exportFlags{},
+		},
 		{
 			Name:        "generate",
 			Alias:       "g",
@@ -18,6 +62,7 @@ func createRunner() (runner *acmd.Runner) {
 					Alias:       "jd",
 					Description: "Generates a jd config based on the provided players scores",
 					ExecFunc:    handleJDGenCmd,
+					FlagSet:     &jdGenFlags{},
 				},
 			},
 		},
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"playerAnalyzer/utils"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConfigPath = "playerAnalyzer.json"
+	envPrefix         = "PLAYERANALYZER_"
+)
+
+// Config is the optional configuration file. Defaults and profiles map flag names to values;
+// a value is used unless the flag is passed or set through PLAYERANALYZER_<FLAG> in the environment.
+type Config struct {
+	Defaults map[string]any            `json:"defaults"`
+	Profiles map[string]map[string]any `json:"profiles"`
+	// CacheTTLs maps url prefixes to durations like "10m" or "immutable"
+	CacheTTLs map[string]string `json:"cacheTTLs"`
+}
+
+// builtinProfiles are available without a config file and may be replaced by one
+var builtinProfiles = map[string]map[string]any{
+	"quick": {
+		"count": 50,
+	},
+	"thorough": {
+		"count":       500,
+		"concurrency": 8,
+	},
+}
+
+// registerConfigFlags adds the flags selecting the config file and profile to fs
+func registerConfigFlags(fs *flag.FlagSet) {
+	fs.String("config", "", "config file with defaults and profiles (default "+defaultConfigPath+" if it exists)")
+	fs.String("profile", "", "named profile of the config file to use, e.g. quick or thorough")
+}
+
+// LoadConfig reads the config file at path. If required is false a missing file yields an empty config.
+func LoadConfig(path string, required bool) (*Config, error) {
+	cfg := &Config{}
+
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as written, as float64 large integers would be formatted like 1e+06 and fail to parse as int flags
+	dec := json.NewDecoder(strings.NewReader(string(bytes)))
+	dec.UseNumber()
+	if err = dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	slog.Debug("Loaded config " + path)
+	return cfg, nil
+}
+
+// applyConfig sets all flags of fs that were not passed explicitly from, in this order,
+// the environment, the selected profile and the config defaults. Flags set this way are added to set.
+func applyConfig(fs *flag.FlagSet, set map[string]bool) error {
+	path, required := layeredValue(fs, set, "config"), true
+	if path == "" {
+		path, required = defaultConfigPath, false
+	}
+	cfg, err := LoadConfig(path, required)
+	if err != nil {
+		return err
+	}
+
+	var profile map[string]any
+	if name := layeredValue(fs, set, "profile"); name != "" {
+		var ok bool
+		if profile, ok = cfg.Profiles[name]; !ok {
+			if profile, ok = builtinProfiles[name]; !ok {
+				return fmt.Errorf("unknown profile %q", name)
+			}
+		}
+		slog.Info("Using profile " + name)
+	}
+
+	if err = checkConfigKeys(cfg.Defaults, "defaults"); err != nil {
+		return err
+	}
+	if err = checkConfigKeys(profile, "profile"); err != nil {
+		return err
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || f.Name == "config" || f.Name == "profile" {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(f.Name))
+		source := envName(f.Name)
+		if !ok {
+			if value, ok = configValue(profile, f.Name); ok {
+				source = "profile"
+			} else if value, ok = configValue(cfg.Defaults, f.Name); ok {
+				source = "config defaults"
+			}
+		}
+		if !ok {
+			return
+		}
+
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s from %s: %w", value, f.Name, source, err))
+			return
+		}
+		set[f.Name] = true
+	})
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return applyCacheTTLs(cfg.CacheTTLs)
+}
+
+// layeredValue returns the value of the flag name, falling back to the environment
+func layeredValue(fs *flag.FlagSet, set map[string]bool, name string) string {
+	if set[name] {
+		return fs.Lookup(name).Value.String()
+	}
+	return os.Getenv(envName(name))
+}
+
+// checkConfigKeys reports keys that are not the name of any flag
+func checkConfigKeys(values map[string]any, where string) error {
+	known := make(map[string]bool)
+	for _, fs := range []*flag.FlagSet{(&jdGenFlags{}).Flags(), (&fetchFlags{}).Flags(), (&syncFlags{}).Flags(), (&exportFlags{}).Flags()} {
+		fs.VisitAll(func(f *flag.Flag) {
+			known[f.Name] = true
+		})
+	}
+
+	var unknown []string
+	for key := range values {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings in config %s: %s", where, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// configValue formats a json value of the config as flag value, lists are joined by commas
+func configValue(values map[string]any, name string) (string, bool) {
+	value, ok := values[name]
+	if !ok {
+		return "", false
+	}
+
+	switch v := value.(type) {
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ","), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyCacheTTLs replaces the time to live of the cache rules with the given prefixes, adding rules for new ones
+func applyCacheTTLs(ttls map[string]string) error {
+	if len(ttls) == 0 {
+		return nil
+	}
+	rules := append([]utils.CacheRule(nil), utils.DefaultCache.Rules...)
+
+	prefixes := make([]string, 0, len(ttls))
+	for prefix := range ttls {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		ttl := utils.Immutable
+		if value := ttls[prefix]; value != "immutable" {
+			var err error
+			if ttl, err = time.ParseDuration(value); err != nil || ttl < 0 {
+				return fmt.Errorf("invalid cache ttl %q for %s, expected a duration like 10m or immutable", value, prefix)
+			}
+		}
+
+		replaced := false
+		for i, rule := range rules {
+			if rule.Prefix == prefix {
+				rules[i].TTL = ttl
+				replaced = true
+			}
+		}
+		if !replaced {
+			// more specific prefixes go first, since the first matching rule wins
+			rules = append([]utils.CacheRule{{Prefix: prefix, TTL: ttl}}, rules...)
+		}
+	}
+
+	utils.DefaultCache.Rules = rules
+	return nil
+}
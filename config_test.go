@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigIntegers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+		"defaults": {"count": 1000000, "seed": 20261018, "max-degree": 3, "min-acc": 95.5},
+		"profiles": {"big": {"concurrency": 16}}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		flag string
+		want string
+	}{
+		{"large count", nil, "count", "1000000"},
+		{"seed", nil, "seed", "20261018"},
+		{"small integer", nil, "max-degree", "3"},
+		{"fraction", nil, "min-acc", "95.5"},
+		{"profile", []string{"--profile", "big"}, "concurrency", "16"},
+		{"flag wins", []string{"--count", "20"}, "count", "20"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := (&jdGenFlags{}).Flags()
+			if _, _, err := parseFlags(fs, append([]string{"--config", path}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+			if got := fs.Lookup(tt.flag).Value.String(); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.flag, got, tt.want)
+			}
+		})
+	}
+}
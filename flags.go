@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"playerAnalyzer/logic"
+	"playerAnalyzer/models"
+	"playerAnalyzer/storage"
+	"playerAnalyzer/utils"
+	"strconv"
+	"time"
+)
+
+// playerFlags holds the options selecting a player and the scores to fetch
+type playerFlags struct {
+	Player      string
+	Count       int
+	Sort        string
+	Ranked      bool
+	Concurrency int
+	Record      string
+	Replay      string
+	Source      string
+	Skipped     string
+}
+
+func (f *playerFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Player, "player", "", "ScoreSaber id, name or ScoreSaber/BeatLeader profile url of the player (may also be passed as first argument)")
+	fs.IntVar(&f.Count, "count", 100, "amount of scores to fetch")
+	fs.StringVar(&f.Sort, "sort", "top", "score sort order (top, recent)")
+	fs.BoolVar(&f.Ranked, "ranked", true, "only use scores on ranked maps")
+	fs.StringVar(&f.Source, "source", storage.SourceScoreSaber, "where to list scores: ss (ScoreSaber, looked up on BeatLeader), bl (BeatLeader only), dir:<path> (datasets written by fetch) or store[:<path>] (plays synced by sync)")
+	fs.IntVar(&f.Concurrency, "concurrency", storage.DefaultConcurrency, "amount of plays fetched in parallel")
+	fs.StringVar(&f.Record, "record", "", "store every API response as fixture in this directory")
+	fs.StringVar(&f.Replay, "replay", "", "answer API requests only from fixtures in this directory, without network")
+	fs.StringVar(&f.Skipped, "skipped", "", "write the skipped plays with their reasons to this json file")
+}
+
+// filterFlags holds the options restricting which plays are used for training
+type filterFlags struct {
+	Stars         string
+	NJS           string
+	Difficulties  string
+	Modes         string
+	Since         string
+	Until         string
+	MinAccuracy   string
+	ExcludeFailed bool
+	ExcludePaused bool
+	ExcludeSpeed  bool
+	AllowHashes   string
+	DenyHashes    string
+}
+
+func (f *filterFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Stars, "stars", "", "star range of the maps, e.g. 5-10, 7- or -9")
+	fs.StringVar(&f.NJS, "njs", "", "NJS range of the maps, e.g. 14-22")
+	fs.StringVar(&f.Difficulties, "diff", "", "comma separated difficulties (Easy, Normal, Hard, Expert, ExpertPlus)")
+	fs.StringVar(&f.Modes, "mode", "", "comma separated characteristics (Standard, OneSaber, NoArrows, 90Degree, 360Degree, Lawless, ...)")
+	fs.StringVar(&f.Since, "since", "", "only plays set on or after this date (YYYY-MM-DD)")
+	fs.StringVar(&f.Until, "until", "", "only plays set on or before this date (YYYY-MM-DD)")
+	fs.StringVar(&f.MinAccuracy, "min-acc", "", "minimum accuracy, as fraction (0.95) or percentage (95)")
+	fs.BoolVar(&f.ExcludeFailed, "exclude-failed", false, "skip plays that were failed")
+	fs.BoolVar(&f.ExcludePaused, "exclude-paused", false, "skip plays with pauses")
+	fs.BoolVar(&f.ExcludeSpeed, "exclude-speed", false, "skip plays with speed modifiers (SF, FS, SS)")
+	fs.StringVar(&f.AllowHashes, "allow-hashes", "", "only use these map hashes (comma separated or @file with one per line)")
+	fs.StringVar(&f.DenyHashes, "deny-hashes", "", "never use these map hashes (comma separated or @file with one per line)")
+}
+
+// filters validates the flags and turns them into models.Filters
+func (f *filterFlags) filters() (filters models.Filters, err error) {
+	if filters.MinStars, filters.MaxStars, err = models.ParseRange(f.Stars); err != nil {
+		return filters, fmt.Errorf("--stars: %w", err)
+	}
+	if filters.MinNJS, filters.MaxNJS, err = models.ParseRange(f.NJS); err != nil {
+		return filters, fmt.Errorf("--njs: %w", err)
+	}
+	if filters.Difficulties, err = models.ParseDifficulties(f.Difficulties); err != nil {
+		return filters, fmt.Errorf("--diff: %w", err)
+	}
+	if filters.Characteristics, err = models.ParseCharacteristics(f.Modes); err != nil {
+		return filters, fmt.Errorf("--mode: %w", err)
+	}
+	if filters.Since, err = models.ParseDate(f.Since); err != nil {
+		return filters, fmt.Errorf("--since: %w", err)
+	}
+	if filters.Until, err = models.ParseDate(f.Until); err != nil {
+		return filters, fmt.Errorf("--until: %w", err)
+	}
+	if filters.Until != nil && len(f.Until) == len("2006-01-02") {
+		// a plain date includes the whole day
+		until := filters.Until.Add(24*time.Hour - time.Nanosecond)
+		filters.Until = &until
+	}
+	if filters.MinAccuracy, err = models.ParseAccuracy(f.MinAccuracy); err != nil {
+		return filters, fmt.Errorf("--min-acc: %w", err)
+	}
+	if filters.AllowHashes, err = models.ParseHashes(f.AllowHashes); err != nil {
+		return filters, fmt.Errorf("--allow-hashes: %w", err)
+	}
+	if filters.DenyHashes, err = models.ParseHashes(f.DenyHashes); err != nil {
+		return filters, fmt.Errorf("--deny-hashes: %w", err)
+	}
+	filters.ExcludeFailed = f.ExcludeFailed
+	filters.ExcludePaused = f.ExcludePaused
+	filters.ExcludeSpeedMods = f.ExcludeSpeed
+	return filters, nil
+}
+
+// jdGenFlags holds the command line options of "generate jd-config"
+type jdGenFlags struct {
+	playerFlags
+	filterFlags
+	From     string
+	Dir      string
+	Format   string
+	Out      string
+	Name     string
+	OnExists string
+	NoOpen   bool
+	JDRange  string
+	// SplitModes trains a separate model for each characteristic
+	SplitModes    bool
+	Clusters      string
+	ClusterMethod string
+	Seed          int64
+	Scaling       string
+	ClusterOn     string
+	Grouping      string
+	MaxDegree     int
+	DegreeBy      string
+	Fit           string
+	Outliers      string
+}
+
+func (f *jdGenFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("jd-config", flag.ContinueOnError)
+	f.playerFlags.register(fs)
+	f.filterFlags.register(fs)
+	registerConfigFlags(fs)
+	fs.StringVar(&f.From, "from", "", "comma separated datasets to train on instead of downloading: files written by fetch or export, or \"latest\"")
+	fs.StringVar(&f.Dir, "dir", storage.DatasetDir, "directory --from latest looks for datasets in")
+	fs.StringVar(&f.Format, "format", "text", "output format of the results (text, json)")
+	fs.StringVar(&f.Out, "out", logic.DefaultOutput.Dir, "directory to write plots, configs and the manifest to")
+	fs.StringVar(&f.Name, "name", logic.DefaultOutput.Template, "file name template; fields: .PlayerId .Name .Sort .Characteristic .Cluster .Date")
+	fs.BoolVar(&f.NoOpen, "no-open", false, "don't open the plot, implied when no display is available")
+	fs.StringVar(&f.JDRange, "jd-range", fmt.Sprintf("%g-%g", utils.JDConfigLow, utils.JDConfigHigh), "NJS range covered by the generated configs")
+	fs.StringVar(&f.Clusters, "clusters", "auto", fmt.Sprintf("amount of JD curves, 1-%d or auto", logic.DefaultModelOptions.MaxClusters))
+	fs.StringVar(&f.ClusterMethod, "cluster-method", logic.DefaultModelOptions.ClusterMethod, "how --clusters auto rates the clusterings (silhouette, bic)")
+	fs.Int64Var(&f.Seed, "seed", logic.DefaultModelOptions.Seed, "seed of the clustering, 0 for a different one every run")
+	fs.StringVar(&f.Scaling, "scaling", logic.DefaultModelOptions.Scaling, "scaling of the features before clustering (zscore, robust, none)")
+	fs.StringVar(&f.ClusterOn, "cluster-on", "", "comma separated features clustered on besides NJS and JD (nps, stars, date)")
+	fs.StringVar(&f.Grouping, "grouping", logic.DefaultModelOptions.Grouping, "how plays are grouped into curves: kmeans by location, mixture by the curve they fit best")
+	fs.IntVar(&f.MaxDegree, "max-degree", logic.DefaultModelOptions.MaxDegree, "highest polynomial degree of the curves")
+	fs.StringVar(&f.DegreeBy, "degree-selection", logic.DefaultModelOptions.DegreeSelection, "how the degree of each curve is chosen (cv, adjr2, aic)")
+	fs.StringVar(&f.Fit, "fit", logic.DefaultModelOptions.Fit, "how curves are fitted (ols, huber, theilsen, ransac)")
+	fs.StringVar(&f.Outliers, "outliers", logic.DefaultModelOptions.Outliers, "rule outliers are dropped by: jd (far from all JDs), residual (far from their curve) or none")
+	fs.BoolVar(&f.SplitModes, "split-modes", false, "train a separate model for each characteristic (Standard, OneSaber, ...)")
+	fs.StringVar(&f.OnExists, "on-exists", string(logic.DefaultOutput.Policy), "what to do with existing files (overwrite, skip, fail)")
+	return fs
+}
+
+// output returns the output options given by the flags
+func (f *jdGenFlags) output() (logic.Output, error) {
+	policy, err := logic.ParseExistsPolicy(f.OnExists)
+	if err != nil {
+		return logic.Output{}, err
+	}
+
+	low, high, err := models.ParseRange(f.JDRange)
+	if err != nil || low <= 0 || high <= 0 {
+		return logic.Output{}, fmt.Errorf("invalid --jd-range %q, expected min-max", f.JDRange)
+	}
+
+	out := logic.Output{
+		Dir:      f.Out,
+		Template: f.Name,
+		Policy:   policy,
+		Open:     !f.NoOpen,
+		NJSLow:   low,
+		NJSHigh:  high,
+		Split:    f.SplitModes,
+	}
+	if out.Open && !utils.HasDisplay() {
+		slog.Debug("No display available, not opening the plot")
+		out.Open = false
+	}
+	return out, out.Validate()
+}
+
+// model returns the modelling options given by the flags
+func (f *jdGenFlags) model() (logic.ModelOptions, error) {
+	opts := logic.DefaultModelOptions
+	opts.ClusterMethod = f.ClusterMethod
+	opts.Seed = f.Seed
+	opts.Scaling = f.Scaling
+	opts.Grouping = f.Grouping
+	opts.MaxDegree = f.MaxDegree
+	opts.DegreeSelection = f.DegreeBy
+	opts.Fit = f.Fit
+	opts.Outliers = f.Outliers
+	features, err := logic.ParseFeatures(f.ClusterOn)
+	if err != nil {
+		return opts, err
+	}
+	opts.Features = features
+	if f.Clusters != "auto" {
+		k, err := strconv.Atoi(f.Clusters)
+		if err != nil || k < 1 {
+			return opts, fmt.Errorf("invalid --clusters %q, expected 1-%d or auto", f.Clusters, opts.MaxClusters)
+		}
+		opts.Clusters = k
+	}
+	return opts, opts.Validate()
+}
+
+// fetchFlags holds the command line options of "fetch"
+type fetchFlags struct {
+	playerFlags
+	filterFlags
+	Dir string
+}
+
+func (f *fetchFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	f.playerFlags.register(fs)
+	f.filterFlags.register(fs)
+	registerConfigFlags(fs)
+	fs.StringVar(&f.Dir, "dir", storage.DatasetDir, "directory to store the dataset in")
+	return fs
+}
+
+// syncFlags holds the command line options of "sync"
+type syncFlags struct {
+	playerFlags
+	Store string
+}
+
+func (f *syncFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	f.playerFlags.register(fs)
+	registerConfigFlags(fs)
+	fs.StringVar(&f.Store, "store", storage.DefaultStorePath, "store file to sync into")
+	return fs
+}
+
+// exportFlags holds the command line options of "export"
+type exportFlags struct {
+	playerFlags
+	filterFlags
+	From   string
+	Dir    string
+	Format string
+	Out    string
+}
+
+func (f *exportFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	f.playerFlags.register(fs)
+	f.filterFlags.register(fs)
+	registerConfigFlags(fs)
+	fs.StringVar(&f.From, "from", "", "comma separated datasets to export instead of downloading: files written by fetch or export, or \"latest\"")
+	fs.StringVar(&f.Dir, "dir", storage.DatasetDir, "directory --from latest looks for datasets in")
+	fs.StringVar(&f.Format, "format", storage.FormatCSV, "export format (csv, columns)")
+	fs.StringVar(&f.Out, "out", "", "file to write, - for stdout (default _cache/exports/<player>-<sort>.<format>)")
+	return fs
+}
+
+// cacheFlags holds the command line options of "cache info" and "cache prune", which need the cache TTLs of the config
+type cacheFlags struct{}
+
+func (f *cacheFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	registerConfigFlags(fs)
+	return fs
+}
+
+// parseFlags parses args into fs, allowing flags and positional arguments to be mixed, and fills in
+// flags that were not passed from the environment and config file.
+// It returns the positional arguments and the names of all flags that got a value this way.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, map[string]bool, error) {
+	var positional []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if fs.Lookup("profile") != nil {
+		if err := applyConfig(fs, set); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return positional, set, nil
+}
+
+// resolvePlayerArgs turns parsed player flags into a player query and settings.
+// Values that were not passed are asked for on stdin if it is a terminal, otherwise defaults are used.
+func resolvePlayerArgs(f *playerFlags, filters models.Filters, positional []string, set map[string]bool) (player string, settings models.Settings, err error) {
+	switch {
+	case len(positional) > 1:
+		return "", settings, fmt.Errorf("unexpected arguments: %v", positional[1:])
+	case len(positional) == 1 && f.Player != "" && f.Player != positional[0]:
+		return "", settings, fmt.Errorf("conflicting players %q and %q", f.Player, positional[0])
+	case len(positional) == 1:
+		f.Player = positional[0]
+	}
+
+	interactive := utils.IsInteractive()
+
+	if f.Player == "" {
+		if !interactive {
+			return "", settings, errors.New("missing player, pass it as argument or via --player")
+		}
+		f.Player, err = utils.GetInput("Enter player id, name or profile url: ")
+		if err != nil {
+			return "", settings, err
+		}
+	}
+
+	if !set["count"] && interactive {
+		if err = promptValue("Enter score count: ", func(c string) error {
+			v, err := models.ParseCount(c)
+			if err == nil {
+				f.Count = v
+			}
+			return err
+		}); err != nil {
+			return "", settings, err
+		}
+	}
+	if !set["sort"] && interactive {
+		if err = promptValue("Enter sort order (1=top, 2=recent): ", func(c string) error {
+			v, err := models.ParseSort(c)
+			if err == nil {
+				f.Sort = v
+			}
+			return err
+		}); err != nil {
+			return "", settings, err
+		}
+	}
+	if !set["ranked"] && interactive {
+		if err = promptValue("Enter ranked status (true,false): ", func(c string) error {
+			v, err := models.ParseRanked(c)
+			if err == nil {
+				f.Ranked = v
+			}
+			return err
+		}); err != nil {
+			return "", settings, err
+		}
+	}
+
+	if f.Concurrency < 1 {
+		return "", settings, fmt.Errorf("invalid concurrency %d, must be at least 1", f.Concurrency)
+	}
+
+	settings, err = models.NewSettings(f.Count, f.Sort, f.Ranked, filters)
+	if err != nil {
+		return "", settings, err
+	}
+
+	return f.Player, settings, nil
+}
+
+// promptValue asks q until parse accepts the answer, an empty answer keeps the default
+func promptValue(q string, parse func(string) error) error {
+	for {
+		answer, err := utils.GetInput(q)
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			return nil
+		}
+		if err = parse(answer); err == nil {
+			return nil
+		}
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+	}
+}
+
+// openSource configures the HTTP client and creates the score source selected by --source
+func openSource(f *playerFlags) (storage.ScoreSource, error) {
+	if err := configureClient(f); err != nil {
+		return nil, err
+	}
+	return storage.NewSource(f.Source, utils.DefaultClient)
+}
+
+// configureClient replaces utils.DefaultClient with one recording or replaying fixtures if requested.
+// The response cache is bypassed in both modes, so every request is recorded and replays never touch it.
+func configureClient(f *playerFlags) error {
+	if f.Record != "" && f.Replay != "" {
+		return errors.New("--record and --replay cannot be used together")
+	}
+
+	var transport *utils.FixtureTransport
+	var err error
+
+	switch {
+	case f.Record != "":
+		transport, err = utils.NewFixtureTransport(f.Record, utils.FixtureRecord)
+	case f.Replay != "":
+		transport, err = utils.NewFixtureTransport(f.Replay, utils.FixtureReplay)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	client := utils.NewClient(transport)
+	client.Cache = nil
+	if transport.Mode == utils.FixtureReplay {
+		// recorded responses don't change, retrying a missing fixture or a recorded error only waits for the same answer
+		client.Limiter = nil
+		client.MaxRetries = 0
+	}
+	utils.DefaultClient = client
+
+	return nil
+}
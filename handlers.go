@@ -2,60 +2,584 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"playerAnalyzer/logic"
 	"playerAnalyzer/models"
+	"playerAnalyzer/storage"
 	"playerAnalyzer/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 )
 
 // handleJDGenCmd Concept by HalloTheEngineer; logic implementation by Claude 3.7 Sonnet
 func handleJDGenCmd(ctx context.Context, args []string) (err error) {
-	_ = os.MkdirAll("_cache/plots", os.ModePerm)
-	_ = os.MkdirAll("_cache/jd_configs", os.ModePerm)
+	var f jdGenFlags
+	positional, set, err := parseFlags(f.Flags(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.Format != "text" && f.Format != "json" {
+		return fmt.Errorf("invalid format %q, expected text or json", f.Format)
+	}
+	out, err := f.output()
+	if err != nil {
+		return err
+	}
+	opts, err := f.model()
+	if err != nil {
+		return err
+	}
+	filters, err := f.filters()
+	if err != nil {
+		return err
+	}
+
+	src, err := openSource(&f.playerFlags)
+	if err != nil {
+		return err
+	}
+
+	if f.From != "" {
+		ds, err := loadDatasetArg(ctx, f.From, f.Dir, f.Player, src, positional)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("Using dataset of %s fetched at %s", ds.Player.Name, ds.FetchedAt.Format("2006-01-02 15:04")))
+
+		// filters given now replace the same filters used when fetching, the others still apply
+		ds.Settings.Filters = ds.Settings.Filters.Merge(filters)
+		if err = ds.Settings.Validate(); err != nil {
+			return err
+		}
+		reports, err := generateJDConfigs(ds.Player, ds.Settings, ds.Results, out, opts, f.SplitModes)
+		if err != nil {
+			return err
+		}
+		return writeReports(reports, f.Format, f.SplitModes)
+	}
+
+	query, settings, err := resolvePlayerArgs(&f.playerFlags, filters, positional, set)
+	if err != nil {
+		return err
+	}
+
+	player, stats, summary, err := fetchPlayerStats(ctx, src, query, settings, f.Concurrency)
+	if err != nil {
+		return err
+	}
+
+	reports, err := generateJDConfigs(player, settings, stats, out, opts, f.SplitModes)
+	if err != nil {
+		return err
+	}
+	if err = reportFetch(&f.playerFlags, summary, reports); err != nil {
+		return err
+	}
+	return writeReports(reports, f.Format, f.SplitModes)
+}
+
+// generateJDConfigs trains a single model on all plays, or one per characteristic if split is set
+func generateJDConfigs(player *utils.SSPlayer, settings models.Settings, stats []*utils.StatsResult, out logic.Output, opts logic.ModelOptions, split bool) ([]*logic.JDReport, error) {
+	if !split {
+		report, err := logic.GenerateJDConfig(player, settings, stats, out, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []*logic.JDReport{report}, nil
+	}
+
+	var reports []*logic.JDReport
+	for _, group := range logic.GroupByCharacteristic(stats) {
+		slog.Info(fmt.Sprintf("Training model for %s with %d plays", group.Characteristic, len(group.Stats)))
+		out.Characteristic = group.Characteristic
+		report, err := logic.GenerateJDConfig(player, settings, group.Stats, out, opts)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// writeReports prints reports to stdout; logs go to stderr, so json output stays parsable.
+// Split runs produce a json array with one report per characteristic, otherwise a single report is written.
+func writeReports(reports []*logic.JDReport, format string, split bool) error {
+	if format == "json" {
+		if !split {
+			return reports[0].WriteJSON(os.Stdout)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "   ")
+		return enc.Encode(reports)
+	}
+	for _, report := range reports {
+		if err := report.WriteText(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleFetchCmd downloads a players plays and stores them as dataset for later generate runs
+func handleFetchCmd(ctx context.Context, args []string) (err error) {
+	var f fetchFlags
+	positional, set, err := parseFlags(f.Flags(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	filters, err := f.filters()
+	if err != nil {
+		return err
+	}
+	src, err := openSource(&f.playerFlags)
+	if err != nil {
+		return err
+	}
+	query, settings, err := resolvePlayerArgs(&f.playerFlags, filters, positional, set)
+	if err != nil {
+		return err
+	}
+
+	player, stats, summary, err := fetchPlayerStats(ctx, src, query, settings, f.Concurrency)
+	if err != nil {
+		return err
+	}
+	if err = reportFetch(&f.playerFlags, summary, nil); err != nil {
+		return err
+	}
+
+	path, err := storage.SaveDataset(f.Dir, storage.NewDataset(f.Source, player, settings, stats))
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Saved %d plays to \"%s\"", len(stats), path))
+
+	return nil
+}
 
-	var playerId string
-	var settings = models.Settings{
-		Count:  100,
-		Sort:   "top",
-		Ranked: true,
+// handleSyncCmd pulls the scores set since the last sync into the local store
+func handleSyncCmd(ctx context.Context, args []string) (err error) {
+	var f syncFlags
+	positional, set, err := parseFlags(f.Flags(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
 	}
 
-	playerId, err = utils.GetInput("Enter player id: ")
+	if f.Source == storage.SourceStore || strings.HasPrefix(f.Source, storage.SourceStore+":") {
+		return errors.New("cannot sync from a store, use --source ss, bl or dir:<path>")
+	}
+	store, err := storage.OpenStore(f.Store)
+	if err != nil {
+		return err
+	}
+	src, err := openSource(&f.playerFlags)
 	if err != nil {
 		return err
 	}
 
-	lCount, err := utils.GetInput("Enter score count: ")
+	// scores are always synced by recency, there is nothing to ask for
+	f.Sort = "recent"
+	set["sort"] = true
+	query, settings, err := resolvePlayerArgs(&f.playerFlags, models.Filters{}, positional, set)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Fetching player info")
+	player, err := resolvePlayer(ctx, src, query)
+	if err != nil {
+		return err
+	}
+
+	progress, done := newProgress("Syncing plays")
+	res, err := storage.Sync(ctx, store, src, player, settings, f.Concurrency, progress)
+	done()
+	if err != nil {
+		return err
+	}
+	if err = reportFetch(&f.playerFlags, res.Summary, nil); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Synced %d of %d new scores of %s, %d plays stored in \"%s\"", res.Added, res.Summary.Listed, player.Name, res.Total, store.Path()))
+	return nil
+}
+
+// handleExportCmd flattens a players plays into a csv or columnar file for analysis elsewhere
+func handleExportCmd(ctx context.Context, args []string) (err error) {
+	var f exportFlags
+	positional, set, err := parseFlags(f.Flags(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	settings.SetCount(lCount)
 
-	lSort, err := utils.GetInput("Enter sort order (1=top, 2=recent): ")
+	if f.Format != storage.FormatCSV && f.Format != storage.FormatColumns {
+		return fmt.Errorf("invalid format %q, expected %s or %s", f.Format, storage.FormatCSV, storage.FormatColumns)
+	}
+	filters, err := f.filters()
+	if err != nil {
+		return err
+	}
+	src, err := openSource(&f.playerFlags)
 	if err != nil {
 		return err
 	}
-	settings.SetSort(lSort)
 
-	lRanked, err := utils.GetInput("Enter ranked status (true,false): ")
+	var player *utils.SSPlayer
+	var settings models.Settings
+	var stats []*utils.StatsResult
+	if f.From != "" {
+		ds, err := loadDatasetArg(ctx, f.From, f.Dir, f.Player, src, positional)
+		if err != nil {
+			return err
+		}
+		player, settings, stats = ds.Player, ds.Settings, ds.Results
+	} else {
+		query, s, err := resolvePlayerArgs(&f.playerFlags, filters, positional, set)
+		if err != nil {
+			return err
+		}
+		settings = s
+		var summary *storage.FetchSummary
+		if player, stats, summary, err = fetchPlayerStats(ctx, src, query, settings, f.Concurrency); err != nil {
+			return err
+		}
+		if err = reportFetch(&f.playerFlags, summary, nil); err != nil {
+			return err
+		}
+	}
+
+	plays := storage.ExportPlays(player, logic.FilterStats(stats, filters))
+
+	if f.Out == "-" {
+		return storage.WriteExport(os.Stdout, f.Format, plays)
+	}
+	path := f.Out
+	if path == "" {
+		path = filepath.Join("_cache", "exports", fmt.Sprintf("%s-%s.%s", player.Id, settings.Sort, exportExt(f.Format)))
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
-	settings.SetRanked(lRanked)
+	if err = storage.WriteExport(file, f.Format, plays); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Exported %d plays to \"%s\"", len(plays), path))
+	return nil
+}
+
+func exportExt(format string) string {
+	if format == storage.FormatColumns {
+		return "columns.json"
+	}
+	return format
+}
 
+func fetchPlayerStats(ctx context.Context, src storage.ScoreSource, query string, settings models.Settings, concurrency int) (*utils.SSPlayer, []*utils.StatsResult, *storage.FetchSummary, error) {
 	slog.Info("Fetching player info")
 
-	player, err := utils.FetchToStruct[utils.SSPlayer](fmt.Sprintf("https://scoresaber.com/api/player/%s/basic", playerId))
+	player, err := resolvePlayer(ctx, src, query)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	slog.Info(fmt.Sprintf("Using player %s (%s)", player.Name, player.Id))
+
+	slog.Info("Loading player's replays...")
+
+	progress, done := newProgress("Fetching plays")
+	stats, summary, err := storage.Collect(ctx, src, player.Id, settings, concurrency, progress)
+	done()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if utils.DefaultClient.Cache != nil {
+		hits, misses := utils.DefaultClient.Cache.Stats()
+		slog.Info(fmt.Sprintf("Cache: %d hits, %d misses", hits, misses))
+	}
+
+	return player, stats, summary, nil
+}
+
+// newProgress returns a progress bar on terminals and a log line every tenth of the plays otherwise.
+// done must be called once fetching finished.
+func newProgress(label string) (progress storage.Progress, done func()) {
+	if utils.IsTerminal(os.Stderr) {
+		bar := utils.NewProgressBar(os.Stderr, label)
+		return bar.Update, bar.Finish
+	}
+
+	var mu sync.Mutex
+	logged := 0
+	return func(n, total int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if step := n * 10 / max(total, 1); step > logged || n == total {
+			logged = step
+			slog.Info(fmt.Sprintf("%s: %d/%d", label, n, total))
+		}
+	}, func() {}
+}
+
+// reportFetch prints the fetch summary to stderr and writes the skipped plays if --skipped is given.
+// reports add the plays dropped while training, nil if nothing was trained.
+func reportFetch(f *playerFlags, summary *storage.FetchSummary, reports []*logic.JDReport) error {
+	labels := map[string]string{
+		storage.SkipUnranked:           "skipped as unranked",
+		storage.SkipFiltered:           "filtered before fetching",
+		storage.SkipMissingScore:       "missing on BeatLeader",
+		storage.SkipMissingLeaderboard: "missing leaderboard",
+		storage.SkipMissingStats:       "missing stats",
+	}
+
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "SCORES\tCOUNT\n")
+	_, _ = fmt.Fprintf(tw, "listed\t%d\n", summary.Listed)
+	_, _ = fmt.Fprintf(tw, "fetched\t%d\n", summary.Fetched)
+	for _, reason := range storage.SkipReasons {
+		// only ScoreSaber lists unranked scores to skip, the other sources leave them out while listing
+		if reason == storage.SkipUnranked && f.Source != storage.SourceScoreSaber {
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\n", labels[reason], summary.Count(reason))
+	}
+	if reports != nil {
+		filtered, outliers := 0, 0
+		for _, r := range reports {
+			filtered += r.Points.Filtered
+			outliers += r.Points.Outliers
+		}
+		_, _ = fmt.Fprintf(tw, "filtered before training\t%d\n", filtered)
+		_, _ = fmt.Fprintf(tw, "outliers removed\t%d\n", outliers)
+	}
+	_ = tw.Flush()
+
+	if f.Skipped == "" {
+		return nil
+	}
+	skipped := summary.Skipped
+	if skipped == nil {
+		skipped = []storage.SkippedPlay{}
+	}
+	bts, err := json.MarshalIndent(skipped, "", "   ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(f.Skipped, bts, 0666); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Wrote %d skipped plays to \"%s\"", len(summary.Skipped), f.Skipped))
+	return nil
+}
+
+// resolvePlayer looks up the player matching query, letting the user pick one if several players match
+func resolvePlayer(ctx context.Context, src storage.ScoreSource, query string) (*utils.SSPlayer, error) {
+	players, err := src.ResolvePlayer(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(players) == 1 {
+		return players[0], nil
+	}
+
+	if !utils.IsInteractive() {
+		msg := fmt.Sprintf("%d players match %q, pass one of the ids instead:", len(players), query)
+		for _, p := range players {
+			msg += fmt.Sprintf("\n  %s  %s (%s, #%d)", p.Id, p.Name, p.Country, p.Rank)
+		}
+		return nil, errors.New(msg)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "%d players match %q:\n", len(players), query)
+	for i, p := range players {
+		_, _ = fmt.Fprintf(os.Stderr, "  %d) %s (%s, #%d) - %s\n", i+1, p.Name, p.Country, p.Rank, p.Id)
+	}
+	for {
+		choice, err := utils.GetInput("Select player: ")
+		if err != nil {
+			return nil, err
+		}
+		i, err := strconv.Atoi(choice)
+		if err == nil && i >= 1 && i <= len(players) {
+			return players[i-1], nil
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Please enter a number between 1 and %d\n", len(players))
+	}
+}
+
+// loadDatasetArg loads the comma separated datasets given by --from and merges them into one.
+// Each entry is a dataset written by fetch, a file written by export, or "latest" for the newest dataset of the player in dir.
+func loadDatasetArg(ctx context.Context, from string, dir string, playerId string, src storage.ScoreSource, positional []string) (*storage.Dataset, error) {
+	var datasets []*storage.Dataset
+	for _, entry := range strings.Split(from, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var ds *storage.Dataset
+		var err error
+		if entry == "latest" {
+			ds, err = loadLatestDataset(ctx, dir, playerId, src, positional)
+		} else {
+			ds, err = storage.LoadFile(entry)
+		}
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, ds)
+	}
+
+	if len(datasets) == 0 {
+		return nil, errors.New("--from requires at least one file")
+	}
+	if len(datasets) > 1 {
+		slog.Info(fmt.Sprintf("Merging %d datasets", len(datasets)))
+	}
+	return storage.MergeDatasets(datasets), nil
+}
+
+// loadLatestDataset loads the newest dataset in dir of the player given by --player or the first positional argument
+func loadLatestDataset(ctx context.Context, dir string, playerId string, src storage.ScoreSource, positional []string) (*storage.Dataset, error) {
+	if playerId == "" && len(positional) > 0 {
+		playerId = positional[0]
+	}
+	if playerId == "" {
+		return nil, errors.New("--from latest requires a player")
+	}
+	if !utils.RequireNumbers(playerId) {
+		player, err := resolvePlayer(ctx, src, playerId)
+		if err != nil {
+			return nil, err
+		}
+		playerId = player.Id
+	}
+
+	path, err := storage.LatestDataset(dir, playerId)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadDataset(path)
+}
+
+// handleCacheInfoCmd prints the amount and size of cached responses per host
+func handleCacheInfoCmd(ctx context.Context, args []string) error {
+	var f cacheFlags
+	positional, _, err := parseFlags(f.Flags(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if len(positional) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", positional)
+	}
 
-	err = logic.GenerateJDConfig(player, settings)
+	entries, err := utils.DefaultCache.Entries()
 	if err != nil {
 		return err
 	}
 
+	type hostStats struct {
+		count, expired int
+		size           int64
+	}
+	hosts := make(map[string]*hostStats)
+	var names []string
+	var total hostStats
+
+	for _, entry := range entries {
+		host := utils.HostOf(entry.Url)
+		if entry.Url == "" {
+			host = "(corrupt)"
+		}
+		hs, ok := hosts[host]
+		if !ok {
+			hs = &hostStats{}
+			hosts[host] = hs
+			names = append(names, host)
+		}
+		for _, s := range []*hostStats{hs, &total} {
+			s.count++
+			s.size += entry.Size
+			if entry.Expired {
+				s.expired++
+			}
+		}
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "HOST\tENTRIES\tEXPIRED\tSIZE\n")
+	for _, name := range names {
+		hs := hosts[name]
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f KiB\n", name, hs.count, hs.expired, float64(hs.size)/1024)
+	}
+	_, _ = fmt.Fprintf(tw, "total\t%d\t%d\t%.1f KiB\n", total.count, total.expired, float64(total.size)/1024)
+	_ = tw.Flush()
+
+	fmt.Printf("Cache directory: %s\n", utils.DefaultCache.Dir)
+	return nil
+}
+
+// handleCachePruneCmd removes expired responses from the cache
+func handleCachePruneCmd(ctx context.Context, args []string) error {
+	var f cacheFlags
+	positional, _, err := parseFlags(f.Flags(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", positional)
+	}
+
+	removed, err := utils.DefaultCache.Prune()
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Removed %d expired cache entries", removed))
+	return nil
+}
+
+// handleCacheClearCmd removes all responses from the cache
+func handleCacheClearCmd(ctx context.Context, args []string) error {
+	if err := utils.DefaultCache.Clear(); err != nil {
+		return err
+	}
+	slog.Info("Cleared cache " + utils.DefaultCache.Dir)
 	return nil
 }
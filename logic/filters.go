@@ -0,0 +1,82 @@
+package logic
+
+import (
+	"fmt"
+	"log/slog"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"sort"
+)
+
+// FilterStats returns the plays passing filters, logging how many were dropped for which reason
+func FilterStats(stats []*utils.StatsResult, filters models.Filters) []*utils.StatsResult {
+	var res []*utils.StatsResult
+	rejected := make(map[string]int)
+
+	for _, r := range stats {
+		if reason := filters.Reject(playOf(r)); reason != "" {
+			rejected[reason]++
+			continue
+		}
+		res = append(res, r)
+	}
+
+	reasons := make([]string, 0, len(rejected))
+	for reason := range rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		slog.Info(fmt.Sprintf("Filtered %d plays by %s", rejected[reason], reason))
+	}
+	return res
+}
+
+func playOf(r *utils.StatsResult) models.Play {
+	play := models.Play{
+		Hash:           r.BLLead.Song.Hash,
+		Difficulty:     r.BLLead.Difficulty.DifficultyName,
+		Characteristic: r.BLLead.Difficulty.ModeName,
+		Stars:          r.BLLead.Difficulty.Stars,
+		NJS:            r.BLLead.Difficulty.Njs,
+		Won:            r.Stats.WinTracker.Won,
+		Pauses:         r.Stats.WinTracker.NbOfPause,
+	}
+	if r.Score != nil {
+		play.Time = r.Score.Time()
+		play.Accuracy = r.Score.Accuracy
+		play.Modifiers = r.Score.Modifiers
+	}
+	return play
+}
+
+// CharacteristicGroup holds the plays of a single characteristic
+type CharacteristicGroup struct {
+	Characteristic string
+	Stats          []*utils.StatsResult
+}
+
+// GroupByCharacteristic splits stats by characteristic, the group with most plays first
+func GroupByCharacteristic(stats []*utils.StatsResult) []CharacteristicGroup {
+	var groups []CharacteristicGroup
+	index := make(map[string]int)
+
+	for _, r := range stats {
+		mode := r.BLLead.Difficulty.ModeName
+		if mode == "" {
+			mode = "Standard"
+		}
+		i, ok := index[mode]
+		if !ok {
+			i = len(groups)
+			index[mode] = i
+			groups = append(groups, CharacteristicGroup{Characteristic: mode})
+		}
+		groups[i].Stats = append(groups[i].Stats, r)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Stats) > len(groups[j].Stats)
+	})
+	return groups
+}
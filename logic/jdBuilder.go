@@ -2,32 +2,41 @@ package logic
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"image/color"
 	"log/slog"
 	"os"
 	"playerAnalyzer/models"
-	"playerAnalyzer/storage"
 	"playerAnalyzer/utils"
 	"sort"
+	"time"
 
-	"github.com/sajari/regression"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
 	"gonum.org/v1/plot/vg/draw"
 )
 
-func GenerateJDConfig(player *utils.SSPlayer, settings models.Settings) error {
-	slog.Info("Loading player's replays...")
-
-	stats, err := storage.FetchStats(player.Id, settings)
-	if err != nil {
-		return err
+func GenerateJDConfig(player *utils.SSPlayer, settings models.Settings, stats []*utils.StatsResult, out Output, opts ModelOptions) (*JDReport, error) {
+	slog.Info("Training jd prediction model...")
+	opts = opts.withSeed()
+
+	report := &JDReport{
+		Player: ReportPlayer{
+			Id:      player.Id,
+			Name:    player.Name,
+			Country: player.Country,
+		},
+		Settings:       settings,
+		Summary:        settings.Summary(),
+		Characteristic: out.Characteristic,
+		Points: PointCounts{
+			Fetched: len(stats),
+		},
 	}
 
-	slog.Info("Training jd prediction model...")
+	stats = FilterStats(stats, settings.Filters)
+	report.Points.Filtered = report.Points.Fetched - len(stats)
 
 	var points plotter.XYs
 
@@ -44,47 +53,66 @@ func GenerateJDConfig(player *utils.SSPlayer, settings models.Settings) error {
 			"Consider fetching more replays with different njs values.")
 	}
 
-	points = utils.RemoveOutliers(points, 1.5)
+	filtered := len(points)
+	report.Points.OutlierRule = opts.Outliers
+	if opts.Outliers == OutliersJD {
+		var inliers plotter.XYs
+		var inlierStats []*utils.StatsResult
+		for i, inlier := range utils.InlierMask(points, outlierIQRs) {
+			if inlier {
+				inliers = append(inliers, points[i])
+				inlierStats = append(inlierStats, stats[i])
+			}
+		}
+		points, stats = inliers, inlierStats
+	}
 
 	// Grouping
 	clusters := make([]utils.Cluster, 0)
-	pointClusters := utils.KMeans(points, 2, 300)
-
-	for i := range pointClusters {
-		for j := 0; j < 1; j++ {
-			pointClusters[i] = append(pointClusters[i], plotter.XY{X: 0, Y: 0})
-		}
+	grouped, err := clusterPoints(points, stats, opts)
+	if err != nil {
+		return nil, err
 	}
+	report.Clustering = grouped.report
 
-	if len(pointClusters) > 3 {
-		return errors.New("too many grouped pairs, this is odd")
+	rng := opts.rng()
+	if opts.Outliers == OutliersResidual {
+		points, stats = grouped.removeResidualOutliers(points, stats, opts, rng)
 	}
+	report.Points.Outliers = filtered - len(points)
+	report.Points.Used = len(points)
 
-	for _, clusterPoints := range pointClusters {
-		if len(clusterPoints) < 3 {
+	pointClusters := grouped.groups
+
+	for i, clusterPoints := range pointClusters {
+		if len(clusterPoints) < 2 {
 			continue
 		}
 
-		model, r2 := utils.FitModels(clusterPoints)
-		fmt.Printf("Cluster with %d points - R²: %.4f\n", len(clusterPoints), r2)
-		fmt.Printf("Model formula: %s", model.Formula)
-		fmt.Println()
+		model, fit, err := utils.FitModels(clusterPoints, []plotter.XY{anchor}, opts.MaxDegree, opts.DegreeSelection, opts.Fit, rng)
+		if err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
 
 		clusters = append(clusters, utils.Cluster{
 			Points: clusterPoints,
 			Model:  model,
+			Fit:    fit,
+			Group:  i,
 		})
 	}
 	sort.Slice(clusters, func(i, j int) bool {
 		return clusters[i].Model.R2 > clusters[j].Model.R2
 	})
-
-	if len(clusters) > 2 {
-		clusters = clusters[:2]
-	}
+	assignments, confidence := assignPoints(points, grouped, clusters)
+	report.Assignments = assignments
 
 	p := plot.New()
 	p.Title.Text = "[NJS - JD] Cluster Regression Analysis"
+	if out.Characteristic != "" {
+		p.Title.Text += " - " + out.Characteristic
+	}
 	p.X.Label.Text = "Note Jump Speed"
 	p.Y.Label.Text = "Jump Distance"
 
@@ -111,14 +139,26 @@ func GenerateJDConfig(player *utils.SSPlayer, settings models.Settings) error {
 		s.GlyphStyle.Color = colors[i%len(colors)]
 		s.GlyphStyle.Radius = vg.Points(3)
 		s.GlyphStyle.Shape = draw.CircleGlyph{}
+		if confidence != nil {
+			// the more certain a play belongs to this curve, the more opaque it is drawn
+			s.GlyphStyleFunc = func(j int) draw.GlyphStyle {
+				style := s.GlyphStyle
+				c := colors[i%len(colors)]
+				alpha := 1.0
+				if j < len(confidence[i]) {
+					alpha = 0.15 + 0.85*confidence[i][j]
+				}
+				style.Color = color.NRGBA{R: c.R, G: c.G, B: c.B, A: uint8(alpha * 255)}
+				return style
+			}
+		}
 		p.Add(s)
 
 		// Create regression curve for this cluster
 		minX, maxX := utils.FindRange(cluster.Points, 0)
-		curve, err := utils.EvaluateModel(cluster.Model, minX, maxX, 100)
-		if err != nil {
-			panic(err)
-		}
+		// draw the curve from the anchor it is tied to
+		minX = min(minX, anchor.X)
+		curve := utils.EvaluateModel(cluster.Model, minX, maxX, 100)
 
 		line := make(plotter.XYs, len(curve))
 		for j, pt := range curve {
@@ -138,35 +178,94 @@ func GenerateJDConfig(player *utils.SSPlayer, settings models.Settings) error {
 		p.Legend.Add(fmt.Sprintf("Cluster %d (R² = %.4f)", i+1, cluster.Model.R2), l)
 	}
 
-	plotPath := fmt.Sprintf("_cache/plots/%s-%s.jpg", player.Id, player.Name)
-	err = p.Save(6*vg.Inch, 6*vg.Inch, plotPath)
+	if confidence != nil {
+		p.Legend.Add("Opacity: membership probability")
+	}
+
+	fields := NameFields{
+		PlayerId:       player.Id,
+		Name:           player.Name,
+		Sort:           settings.Sort,
+		Characteristic: out.Characteristic,
+		Date:           time.Now().Format("2006-01-02"),
+	}
+	manifest := ManifestEntry{
+		Time:     time.Now().UTC(),
+		PlayerId: player.Id,
+		Settings: settings,
+	}
+
+	plotPath, err := out.Path("plots", ".jpg", fields)
+	if err != nil {
+		return nil, err
+	}
+	jdPaths := make([]string, len(clusters))
+	for i := range clusters {
+		fields.Cluster = i + 1
+		if jdPaths[i], err = out.Path("jd_configs", ".json", fields); err != nil {
+			return nil, err
+		}
+	}
+	if err = out.CheckExisting(append([]string{plotPath}, jdPaths...)); err != nil {
+		return nil, err
+	}
+
+	status, err := out.Write(plotPath, func(path string) error {
+		return p.Save(6*vg.Inch, 6*vg.Inch, path)
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	manifest.Files = append(manifest.Files, ManifestFile{Path: plotPath, Kind: "plot", Status: status})
+	report.Plot = plotPath
+	if out.Open {
+		if err = utils.OpenFile(plotPath); err != nil {
+			slog.Warn(err.Error())
+		}
 	}
-	utils.OpenFile(plotPath)
 
 	for i, cluster := range clusters {
-		bts, err := buildJDConfig(cluster.Model)
+		bts, err := buildJDConfig(cluster.Model, out.NJSLow, out.NJSHigh)
+		if err != nil {
+			return nil, err
+		}
+
+		jdPath := jdPaths[i]
+		status, err := out.Write(jdPath, func(path string) error {
+			return os.WriteFile(path, *bts, 0666)
+		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		jdPath := fmt.Sprintf("_cache/jd_configs/%s-%s-%s-v%d_%s.json", player.Id, player.Name, settings.Sort, i+1, utils.RandomStr(4))
-		_ = os.WriteFile(jdPath, *bts, 0666)
+		manifest.Files = append(manifest.Files, ManifestFile{Path: jdPath, Kind: "jd_config", Status: status})
 		slog.Info(fmt.Sprintf("Check \"%s\" for generated jd config", jdPath))
+
+		report.Clusters = append(report.Clusters, ClusterReport{
+			Points:       len(cluster.Points),
+			Degree:       cluster.Model.Degree(),
+			Coefficients: cluster.Model.Coeffs,
+			Fit:          opts.Fit,
+			R2:           cluster.Model.R2,
+			Validation:   Validation{Method: cluster.Fit.Method, Score: cluster.Fit.Score},
+			Formula:      cluster.Model.Formula,
+			NJSRange:     njsRangeOf(cluster.Points),
+			Config:       jdPath,
+		})
+	}
+
+	if err = out.AppendManifest(manifest); err != nil {
+		return nil, err
 	}
-	return nil
+	return report, nil
 }
 
-func buildJDConfig(reg *regression.Regression) (*[]byte, error) {
+func buildJDConfig(model *utils.Polynomial, low float64, high float64) (*[]byte, error) {
 	var configPairs []utils.JDPair
-	var njs = utils.JDConfigLow
+	var njs = low
 
-	for njs < utils.JDConfigHigh {
+	for njs < high {
 
-		predictedJD, err := utils.PredictDeg(reg, len(reg.GetCoeffs()), njs)
-		if err != nil {
-			return nil, err
-		}
+		predictedJD := model.Predict(njs)
 
 		configPairs = append(configPairs, utils.JDPair{
 			NJS: njs,
@@ -182,3 +281,44 @@ func buildJDConfig(reg *regression.Regression) (*[]byte, error) {
 
 	return &bytes, nil
 }
+
+// assignPoints reports for every point the curve it was assigned to and its membership probabilities, ordered like
+// clusters. It also returns the membership of each point in its own curve, in the order of the cluster points.
+// Both are nil unless the grouping knows memberships.
+func assignPoints(points plotter.XYs, grouped *grouping, clusters []utils.Cluster) ([]PointAssignment, [][]float64) {
+	if grouped.memberships == nil {
+		return nil, nil
+	}
+
+	order := make(map[int]int, len(clusters))
+	for i, c := range clusters {
+		order[c.Group] = i
+	}
+
+	var assignments []PointAssignment
+	confidence := make([][]float64, len(clusters))
+	for i, p := range points {
+		membership := make([]float64, len(clusters))
+		for g, prob := range grouped.memberships[i] {
+			if c, ok := order[g]; ok {
+				membership[c] = prob
+			}
+		}
+
+		c, ok := order[grouped.labels[i]]
+		if ok {
+			confidence[c] = append(confidence[c], membership[c])
+		} else {
+			// the group was too small to fit a curve, report the most likely remaining one
+			for j := range membership {
+				if membership[j] > membership[c] {
+					c = j
+				}
+			}
+		}
+		if len(clusters) > 0 {
+			assignments = append(assignments, PointAssignment{NJS: p.X, JD: p.Y, Cluster: c, Membership: membership})
+		}
+	}
+	return assignments, confidence
+}
@@ -0,0 +1,316 @@
+package logic
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"playerAnalyzer/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// ModelOptions control how plays are grouped into curves before fitting
+type ModelOptions struct {
+	// Clusters is the amount of JD curves, 0 chooses it between 1 and MaxClusters
+	Clusters    int
+	MaxClusters int
+	// ClusterMethod rates the clusterings when choosing automatically, utils.Silhouette or utils.BIC
+	ClusterMethod string
+	// Seed makes the clustering reproducible, 0 seeds from the clock
+	Seed int64
+	// Scaling is applied to every feature before clustering, one of the utils.Scale* constants
+	Scaling string
+	// Features are clustered on in addition to NJS and JD
+	Features []string
+	// Grouping is GroupKMeans or GroupMixture
+	Grouping string
+	// MaxDegree is the highest polynomial degree of the curves
+	MaxDegree int
+	// DegreeSelection picks the degree of each curve, one of utils.SelectCV, utils.SelectAdjR2 or utils.SelectAIC
+	DegreeSelection string
+	// Fit is how curves are fitted, one of the utils.Fit* constants
+	Fit string
+	// Outliers is the rule plays are dropped as outliers by, one of the Outliers* constants
+	Outliers string
+}
+
+// Rules to drop outliers by
+const (
+	// OutliersJD drops plays whose JD is far from the JD of all plays, before grouping
+	OutliersJD = "jd"
+	// OutliersResidual drops plays far from the curve of their group, so a high NJS play isn't dropped for its high JD
+	OutliersResidual = "residual"
+	OutliersNone     = "none"
+)
+
+// outlierIQRs is how many interquartile ranges a play may be off to not count as an outlier
+const outlierIQRs = 1.5
+
+// maxDegreeLimit keeps --max-degree from producing curves that oscillate wildly outside the played NJS range
+const maxDegreeLimit = 6
+
+// Ways of grouping plays into curves
+const (
+	// GroupKMeans groups plays by where they lie, using k-means on the scaled features
+	GroupKMeans = "kmeans"
+	// GroupMixture groups plays by the curve they fit best, using a mixture of regressions started from k-means
+	GroupMixture = "mixture"
+)
+
+// mixtureDegree is the degree of the curves fitted by the mixture (at most MaxDegree), the final curves are fitted
+// to its groups as usual
+const mixtureDegree = 2
+
+var DefaultModelOptions = ModelOptions{
+	MaxClusters:     4,
+	ClusterMethod:   utils.Silhouette,
+	Seed:            1,
+	Scaling:         utils.ScaleZScore,
+	Grouping:        GroupKMeans,
+	MaxDegree:       4,
+	DegreeSelection: utils.SelectCV,
+	Fit:             utils.FitOLS,
+	Outliers:        OutliersJD,
+}
+
+// Additional features plays can be clustered on
+const (
+	FeatureNPS   = "nps"
+	FeatureStars = "stars"
+	// FeatureDate separates plays by when they were set, e.g. before and after changing the JD config
+	FeatureDate = "date"
+)
+
+var Features = []string{FeatureNPS, FeatureStars, FeatureDate}
+
+// ParseFeatures parses a comma separated list of additional features
+func ParseFeatures(s string) ([]string, error) {
+	var res []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || slices.Contains(res, f) {
+			continue
+		}
+		if !slices.Contains(Features, f) {
+			return nil, fmt.Errorf("invalid feature %q, expected one of %s", f, strings.Join(Features, ", "))
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+// featureValue returns feature of a play, NaN if it is unknown
+func featureValue(r *utils.StatsResult, feature string) float64 {
+	v := 0.0
+	switch feature {
+	case FeatureNPS:
+		v = r.BLLead.Difficulty.Nps
+	case FeatureStars:
+		v = r.BLLead.Difficulty.Stars
+	case FeatureDate:
+		if r.Score != nil && !r.Score.Time().IsZero() {
+			v = float64(r.Score.Time().Unix()) / (24 * 60 * 60)
+		}
+	}
+	// imported files and unrated maps leave these empty
+	if v == 0 {
+		return math.NaN()
+	}
+	return v
+}
+
+func (o ModelOptions) Validate() error {
+	if o.Clusters < 0 || o.Clusters > o.MaxClusters {
+		return fmt.Errorf("invalid cluster count %d, must be between 1 and %d, or 0 to choose automatically", o.Clusters, o.MaxClusters)
+	}
+	if o.ClusterMethod != utils.Silhouette && o.ClusterMethod != utils.BIC {
+		return fmt.Errorf("invalid cluster method %q, expected %s or %s", o.ClusterMethod, utils.Silhouette, utils.BIC)
+	}
+	if o.Scaling != utils.ScaleNone && o.Scaling != utils.ScaleZScore && o.Scaling != utils.ScaleRobust {
+		return fmt.Errorf("invalid scaling %q, expected %s, %s or %s", o.Scaling, utils.ScaleNone, utils.ScaleZScore, utils.ScaleRobust)
+	}
+	if o.MaxDegree < 1 || o.MaxDegree > maxDegreeLimit {
+		return fmt.Errorf("invalid max degree %d, must be between 1 and %d", o.MaxDegree, maxDegreeLimit)
+	}
+	if o.DegreeSelection != utils.SelectCV && o.DegreeSelection != utils.SelectAdjR2 && o.DegreeSelection != utils.SelectAIC {
+		return fmt.Errorf("invalid degree selection %q, expected %s, %s or %s", o.DegreeSelection, utils.SelectCV, utils.SelectAdjR2, utils.SelectAIC)
+	}
+	if o.Fit != utils.FitOLS && o.Fit != utils.FitHuber && o.Fit != utils.FitTheilSen && o.Fit != utils.FitRANSAC {
+		return fmt.Errorf("invalid fit %q, expected %s, %s, %s or %s", o.Fit, utils.FitOLS, utils.FitHuber, utils.FitTheilSen, utils.FitRANSAC)
+	}
+	if o.Outliers != OutliersJD && o.Outliers != OutliersResidual && o.Outliers != OutliersNone {
+		return fmt.Errorf("invalid outlier rule %q, expected %s, %s or %s", o.Outliers, OutliersJD, OutliersResidual, OutliersNone)
+	}
+	if o.Grouping != GroupKMeans && o.Grouping != GroupMixture {
+		return fmt.Errorf("invalid grouping %q, expected %s or %s", o.Grouping, GroupKMeans, GroupMixture)
+	}
+	for _, f := range o.Features {
+		if !slices.Contains(Features, f) {
+			return fmt.Errorf("invalid feature %q, expected one of %s", f, strings.Join(Features, ", "))
+		}
+	}
+	return nil
+}
+
+// withSeed returns o with a seed of 0 replaced by one from the clock, so the seed actually used can be reported
+func (o ModelOptions) withSeed() ModelOptions {
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	return o
+}
+
+func (o ModelOptions) rng() *rand.Rand {
+	return rand.New(rand.NewSource(o.Seed))
+}
+
+// grouping is how the points were split into curves
+type grouping struct {
+	groups [][]plotter.XY
+	labels []int
+	// memberships holds for every point the probability of belonging to each group, nil for k-means
+	memberships [][]float64
+	report      ClusteringReport
+}
+
+// clusterPoints groups points with k-means or a mixture of regressions, choosing k automatically unless opts fixes it.
+// stats are the plays behind points, in the same order, their features are clustered on along with NJS and JD.
+func clusterPoints(points plotter.XYs, stats []*utils.StatsResult, opts ModelOptions) (*grouping, error) {
+	const maxIterations = 300
+
+	g := &grouping{report: ClusteringReport{
+		Grouping: opts.Grouping,
+		Method:   "fixed",
+		Seed:     opts.Seed,
+		Scaling:  opts.Scaling,
+		Features: append([]string{"njs", "jd"}, opts.Features...),
+	}}
+	if len(points) == 0 {
+		return g, nil
+	}
+
+	features := make([][]float64, len(points))
+	for i, p := range points {
+		features[i] = []float64{p.X, p.Y}
+		for _, f := range opts.Features {
+			features[i] = append(features[i], featureValue(stats[i], f))
+		}
+	}
+	features, err := utils.Standardize(features, opts.Scaling)
+	if err != nil {
+		return nil, err
+	}
+
+	rng := opts.rng()
+	if opts.Grouping == GroupMixture {
+		g.fitMixture(points, features, opts, maxIterations, rng)
+	} else if opts.Clusters > 0 {
+		g.report.K = opts.Clusters
+		g.labels = utils.KMeans(features, opts.Clusters, maxIterations, rng)
+	} else {
+		g.labels, g.report.K, g.report.Scores, err = utils.ChooseK(features, opts.MaxClusters, maxIterations, opts.ClusterMethod, rng)
+		if err != nil {
+			return nil, err
+		}
+		g.report.Method = opts.ClusterMethod
+	}
+
+	if g.report.Method != "fixed" {
+		slog.Info(fmt.Sprintf("Chose %d clusters by %s on %s", g.report.K, g.report.Method, strings.Join(g.report.Features, ", ")))
+	}
+	g.groups = utils.GroupPoints(points, g.labels, g.report.K)
+	return g, nil
+}
+
+// fitMixture fits a mixture of regressions for the fixed or every possible k, started from k-means on features.
+// The amount of curves is always chosen by the BIC of the mixture, silhouettes rate locations rather than curves.
+// Like k-means, it never fails: larger k are only tried while mixtures can be fitted, without any all points form
+// a single group.
+func (g *grouping) fitMixture(points plotter.XYs, features [][]float64, opts ModelOptions, maxIterations int, rng *rand.Rand) {
+	degree := min(mixtureDegree, opts.MaxDegree)
+	lowK, highK := opts.Clusters, opts.Clusters
+	if opts.Clusters == 0 {
+		lowK, highK = 1, max(1, min(opts.MaxClusters, len(points)/3))
+		g.report.Method = utils.BIC
+	}
+
+	var best *utils.Mixture
+	for k := lowK; k <= highK && len(points) > degree; k++ {
+		init := utils.KMeans(features, k, maxIterations, rng)
+		m, err := utils.MixtureOfRegressions(points, init, k, degree, maxIterations, rng)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("No mixture of %d curves fits %d plays: %s", k, len(points), err.Error()))
+			break
+		}
+		if opts.Clusters == 0 {
+			g.report.Scores = append(g.report.Scores, m.BIC())
+		}
+		if best == nil || m.BIC() > best.BIC() {
+			best = m
+		}
+	}
+
+	if best == nil {
+		g.report.Method = "fixed"
+		g.report.K = 1
+		g.labels = make([]int, len(points))
+		g.memberships = make([][]float64, len(points))
+		for i := range g.memberships {
+			g.memberships[i] = []float64{1}
+		}
+		return
+	}
+
+	g.report.K = len(best.Components)
+	g.labels = best.Labels()
+	g.memberships = best.Memberships
+}
+
+// anchor is trained on by every fit, it keeps the curves going through the origin
+var anchor = plotter.XY{X: 0, Y: 0}
+
+// removeResidualOutliers fits a curve to every group and drops the points too far from it. It returns the remaining
+// points and their stats, the grouping is updated to match.
+func (g *grouping) removeResidualOutliers(points plotter.XYs, stats []*utils.StatsResult, opts ModelOptions, rng *rand.Rand) (plotter.XYs, []*utils.StatsResult) {
+	masks := make([][]bool, len(g.groups))
+	for i, group := range g.groups {
+		if len(group) < 2 {
+			continue
+		}
+		model, _, err := utils.FitModels(group, []plotter.XY{anchor}, opts.MaxDegree, opts.DegreeSelection, opts.Fit, rng)
+		if err != nil {
+			continue
+		}
+		masks[i] = utils.ResidualInlierMask(group, model, outlierIQRs)
+	}
+
+	var keptPoints plotter.XYs
+	var keptStats []*utils.StatsResult
+	var keptLabels []int
+	var keptMemberships [][]float64
+	seen := make([]int, len(g.groups))
+	for i, p := range points {
+		label := g.labels[i]
+		j := seen[label]
+		seen[label]++
+		if masks[label] != nil && !masks[label][j] {
+			continue
+		}
+
+		keptPoints = append(keptPoints, p)
+		keptStats = append(keptStats, stats[i])
+		keptLabels = append(keptLabels, label)
+		if g.memberships != nil {
+			keptMemberships = append(keptMemberships, g.memberships[i])
+		}
+	}
+
+	g.labels = keptLabels
+	g.memberships = keptMemberships
+	g.groups = utils.GroupPoints(keptPoints, keptLabels, len(g.groups))
+	return keptPoints, keptStats
+}
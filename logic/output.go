@@ -0,0 +1,220 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ExistsPolicy decides what happens when an output file already exists
+type ExistsPolicy string
+
+const (
+	Overwrite ExistsPolicy = "overwrite"
+	Skip      ExistsPolicy = "skip"
+	Fail      ExistsPolicy = "fail"
+)
+
+const DefaultNameTemplate = "{{.PlayerId}}-{{.Name}}-{{.Sort}}{{if .Characteristic}}-{{.Characteristic}}{{end}}{{if .Cluster}}-c{{.Cluster}}{{end}}"
+
+const manifestName = "manifest.json"
+
+// Output describes where GenerateJDConfig writes its plots and configs
+type Output struct {
+	Dir      string
+	Template string
+	Policy   ExistsPolicy
+	// Open shows the plot in the default image viewer once it is written
+	Open bool
+	// NJSLow and NJSHigh are the NJS range covered by the generated configs
+	NJSLow  float64
+	NJSHigh float64
+	// Characteristic is set when a separate model is trained for each characteristic
+	Characteristic string
+	// Split is set if a separate model is trained for each characteristic, so names must tell them apart
+	Split bool
+}
+
+// NameFields are the values available in the file name template
+type NameFields struct {
+	PlayerId string
+	Name     string
+	Sort     string
+	// Characteristic is empty unless models are trained per characteristic
+	Characteristic string
+	// Cluster is 0 for files not belonging to a single cluster, like the plot
+	Cluster int
+	Date    string
+}
+
+// ManifestEntry lists the files produced by a single run
+type ManifestEntry struct {
+	Time     time.Time       `json:"time"`
+	PlayerId string          `json:"playerId"`
+	Settings models.Settings `json:"settings"`
+	Files    []ManifestFile  `json:"files"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+}
+
+var DefaultOutput = Output{
+	Dir:      "_cache",
+	Template: DefaultNameTemplate,
+	Policy:   Overwrite,
+	Open:     true,
+	NJSLow:   utils.JDConfigLow,
+	NJSHigh:  utils.JDConfigHigh,
+}
+
+func ParseExistsPolicy(s string) (ExistsPolicy, error) {
+	switch p := ExistsPolicy(s); p {
+	case Overwrite, Skip, Fail:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid policy %q, expected overwrite, skip or fail", s)
+}
+
+// Validate checks that the name template executes and gives every file of a run its own name
+func (o Output) Validate() error {
+	fields := NameFields{PlayerId: "1", Name: "n", Sort: "top", Cluster: 1, Date: "2006-01-02"}
+	if o.Split {
+		fields.Characteristic = "Standard"
+	}
+	first, err := o.name(fields)
+	if err != nil {
+		return err
+	}
+
+	fields.Cluster = 2
+	if second, err := o.name(fields); err != nil || second == first {
+		return fmt.Errorf("invalid --name %q, the configs of different clusters get the same name; add {{.Cluster}}", o.Template)
+	}
+
+	if o.Split {
+		fields.Cluster = 1
+		fields.Characteristic = "OneSaber"
+		if other, err := o.name(fields); err != nil || other == first {
+			return fmt.Errorf("invalid --name %q, the files of different characteristics get the same name with --split-modes; add {{.Characteristic}}", o.Template)
+		}
+	}
+	return nil
+}
+
+// Path returns the path of a file of the given kind ("plots", "jd_configs") and extension
+func (o Output) Path(kind string, ext string, fields NameFields) (string, error) {
+	name, err := o.name(fields)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(o.Dir, kind, name+ext), nil
+}
+
+func (o Output) name(fields NameFields) (string, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(o.Template)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, fields); err != nil {
+		return "", fmt.Errorf("invalid name template: %w", err)
+	}
+
+	name := sanitizeFileName(buf.String())
+	if name == "" {
+		return "", errors.New("name template produced an empty file name")
+	}
+	return name, nil
+}
+
+// CheckExisting fails on the first of paths that already exists if the policy is fail,
+// so a run stops before writing any of its files instead of leaving some of them behind
+func (o Output) CheckExisting(paths []string) error {
+	if o.Policy != Fail {
+		return nil
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+	}
+	return nil
+}
+
+// Write calls write for path unless the exists policy forbids it and returns the resulting manifest status
+func (o Output) Write(path string, write func(path string) error) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	status := "created"
+	if _, err := os.Stat(path); err == nil {
+		switch o.Policy {
+		case Skip:
+			slog.Info(fmt.Sprintf("Skipping \"%s\", it already exists", path))
+			return "skipped", nil
+		case Fail:
+			return "", fmt.Errorf("%s already exists", path)
+		}
+		status = "overwritten"
+	}
+
+	if err := write(path); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// AppendManifest adds entry to the manifest file in the output directory
+func (o Output) AppendManifest(entry ManifestEntry) error {
+	path := filepath.Join(o.Dir, manifestName)
+
+	var entries []ManifestEntry
+	bts, err := os.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(bts, &entries); err != nil {
+			return fmt.Errorf("failed to parse manifest %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	entries = append(entries, entry)
+
+	bts, err = json.MarshalIndent(entries, "", "   ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(o.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bts, 0666)
+}
+
+// sanitizeFileName replaces characters that are not allowed in file names on common platforms
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 32 {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Trim(strings.TrimSpace(name), ".")
+}
@@ -0,0 +1,168 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"strings"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// JDReport is the machine-readable result of GenerateJDConfig
+type JDReport struct {
+	Player   ReportPlayer    `json:"player"`
+	Settings models.Settings `json:"settings"`
+	// Summary describes the settings in words
+	Summary string `json:"summary"`
+	// Characteristic is set if the report only covers plays of a single characteristic
+	Characteristic string           `json:"characteristic,omitempty"`
+	Points         PointCounts      `json:"points"`
+	Clustering     ClusteringReport `json:"clustering"`
+	Clusters       []ClusterReport  `json:"clusters"`
+	// Assignments are only reported by the mixture, for every used play
+	Assignments []PointAssignment `json:"assignments,omitempty"`
+	Plot        string            `json:"plot"`
+}
+
+// PointAssignment tells which curve a play was assigned to and how likely it belongs to each of them
+type PointAssignment struct {
+	NJS float64 `json:"njs"`
+	JD  float64 `json:"jd"`
+	// Cluster is the index in Clusters
+	Cluster int `json:"cluster"`
+	// Membership is the probability of belonging to each of Clusters, in the same order
+	Membership []float64 `json:"membership"`
+}
+
+type ReportPlayer struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Country string `json:"country"`
+}
+
+// PointCounts tracks how many plays made it into training
+type PointCounts struct {
+	Fetched  int `json:"fetched"`
+	Filtered int `json:"filtered"`
+	Outliers int `json:"outliers"`
+	// OutlierRule is the rule outliers were dropped by, one of the Outliers* constants
+	OutlierRule string `json:"outlierRule"`
+	Used        int    `json:"used"`
+}
+
+// ClusteringReport tells how the amount of curves was chosen
+type ClusteringReport struct {
+	// Grouping is GroupKMeans or GroupMixture
+	Grouping string `json:"grouping"`
+	// Method is utils.Silhouette, utils.BIC or "fixed" if the amount was given
+	Method string `json:"method"`
+	K      int    `json:"k"`
+	Seed   int64  `json:"seed"`
+	// Scores of each k tried, starting with k=1
+	Scores []float64 `json:"scores,omitempty"`
+	// Scaling applied to Features before clustering
+	Scaling  string   `json:"scaling"`
+	Features []string `json:"features"`
+}
+
+type ClusterReport struct {
+	Points int `json:"points"`
+	Degree int `json:"degree"`
+	// Coefficients of the polynomial, starting with the intercept
+	Coefficients []float64 `json:"coefficients"`
+	R2           float64   `json:"r2"`
+	// Fit is the method the curve was fitted with, one of the utils.Fit* constants
+	Fit string `json:"fit"`
+	// Validation is the score the degree was selected by, unlike R² it accounts for overfitting
+	Validation Validation `json:"validation"`
+	Formula    string     `json:"formula"`
+	NJSRange   NJSRange   `json:"njsRange"`
+	Config     string     `json:"config"`
+}
+
+type Validation struct {
+	// Method is utils.SelectCV (cross-validated RMSE), utils.SelectAdjR2 or utils.SelectAIC
+	Method string  `json:"method"`
+	Score  float64 `json:"score"`
+}
+
+var validationLabels = map[string]string{
+	utils.SelectCV:    "CV RMSE",
+	utils.SelectAdjR2: "adjusted R²",
+	utils.SelectAIC:   "AIC",
+}
+
+type NJSRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// njsRangeOf returns the NJS range covered by actual plays, ignoring the origin anchor added to each cluster
+func njsRangeOf(points plotter.XYs) NJSRange {
+	var plays plotter.XYs
+	for _, p := range points {
+		if p.X != 0 || p.Y != 0 {
+			plays = append(plays, p)
+		}
+	}
+	minX, maxX := utils.FindRange(plays, 0)
+	return NJSRange{Min: minX, Max: maxX}
+}
+
+func (r *JDReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "   ")
+	return enc.Encode(r)
+}
+
+func (r *JDReport) WriteText(w io.Writer) error {
+	name := r.Player.Name
+	if r.Characteristic != "" {
+		name += " " + r.Characteristic
+	}
+	_, err := fmt.Fprintf(w, "Trained on %d of %d plays of %s (%s)\n", r.Points.Used, r.Points.Fetched, name, r.Summary)
+	if err != nil {
+		return err
+	}
+	grouping := ""
+	if r.Clustering.Grouping == GroupMixture {
+		grouping = " of a mixture of regressions"
+	}
+	if r.Clustering.Scores != nil {
+		_, err = fmt.Fprintf(w, "Using %d clusters%s chosen by %s (scores %s)\n", r.Clustering.K, grouping, r.Clustering.Method, formatScores(r.Clustering.Scores))
+	} else {
+		_, err = fmt.Fprintf(w, "Using %d clusters%s\n", r.Clustering.K, grouping)
+	}
+	if err != nil {
+		return err
+	}
+	if len(r.Clustering.Features) > 2 {
+		_, err = fmt.Fprintf(w, "Clustered on %s (%s scaling)\n", strings.Join(r.Clustering.Features, ", "), r.Clustering.Scaling)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range r.Clusters {
+		fit := ""
+		if c.Fit != utils.FitOLS {
+			fit = ", " + c.Fit + " fit"
+		}
+		_, err = fmt.Fprintf(w, "Cluster with %d points - degree %d%s, R²: %.4f, %s: %.4f\nModel formula: %s\n",
+			c.Points, c.Degree, fit, c.R2, validationLabels[c.Validation.Method], c.Validation.Score, c.Formula)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatScores(scores []float64) string {
+	parts := make([]string, len(scores))
+	for i, s := range scores {
+		parts[i] = fmt.Sprintf("k=%d: %.3f", i+1, s)
+	}
+	return strings.Join(parts, ", ")
+}
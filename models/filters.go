@@ -0,0 +1,282 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"playerAnalyzer/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var speedModifiers = []string{"SF", "FS", "SS"}
+
+// Filters restrict which plays are used for training, zero values don't filter
+type Filters struct {
+	MinStars float64 `json:"minStars,omitempty"`
+	MaxStars float64 `json:"maxStars,omitempty"`
+	MinNJS   float64 `json:"minNjs,omitempty"`
+	MaxNJS   float64 `json:"maxNjs,omitempty"`
+
+	Difficulties    []string   `json:"difficulties,omitempty"`
+	Characteristics []string   `json:"characteristics,omitempty"`
+	Since           *time.Time `json:"since,omitempty"`
+	Until           *time.Time `json:"until,omitempty"`
+	// MinAccuracy is a fraction between 0 and 1
+	MinAccuracy float64 `json:"minAccuracy,omitempty"`
+
+	ExcludeFailed    bool `json:"excludeFailed,omitempty"`
+	ExcludePaused    bool `json:"excludePaused,omitempty"`
+	ExcludeSpeedMods bool `json:"excludeSpeedMods,omitempty"`
+
+	AllowHashes []string `json:"allowHashes,omitempty"`
+	DenyHashes  []string `json:"denyHashes,omitempty"`
+}
+
+// Play holds the properties of a play the filters look at
+type Play struct {
+	Hash           string
+	Difficulty     string
+	Characteristic string
+	Stars          float64
+	NJS            float64
+	Time           time.Time
+	Accuracy       float64
+	Won            bool
+	Pauses         int
+	Modifiers      string
+}
+
+func (f *Filters) AcceptsDifficulty(name string) bool {
+	if len(f.Difficulties) == 0 {
+		return true
+	}
+	for _, d := range f.Difficulties {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filters) AcceptsCharacteristic(name string) bool {
+	if len(f.Characteristics) == 0 {
+		return true
+	}
+	for _, c := range f.Characteristics {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filters) AcceptsHash(hash string) bool {
+	for _, h := range f.DenyHashes {
+		if strings.EqualFold(h, hash) {
+			return false
+		}
+	}
+	if len(f.AllowHashes) == 0 {
+		return true
+	}
+	for _, h := range f.AllowHashes {
+		if strings.EqualFold(h, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reject returns why p does not pass the filters, or an empty string if it does
+func (f *Filters) Reject(p Play) string {
+	switch {
+	case !f.AcceptsHash(p.Hash):
+		return "map hash"
+	case !f.AcceptsDifficulty(p.Difficulty):
+		return "difficulty"
+	case !f.AcceptsCharacteristic(p.Characteristic):
+		return "characteristic"
+	case f.MinStars > 0 && p.Stars < f.MinStars, f.MaxStars > 0 && p.Stars > f.MaxStars:
+		return "stars"
+	case f.MinNJS > 0 && p.NJS < f.MinNJS, f.MaxNJS > 0 && p.NJS > f.MaxNJS:
+		return "njs"
+	case f.Since != nil && p.Time.Before(*f.Since), f.Until != nil && p.Time.After(*f.Until):
+		return "date"
+	case f.MinAccuracy > 0 && p.Accuracy < f.MinAccuracy:
+		return "accuracy"
+	case f.ExcludeFailed && !p.Won:
+		return "failed"
+	case f.ExcludePaused && p.Pauses > 0:
+		return "paused"
+	case f.ExcludeSpeedMods && HasSpeedModifier(p.Modifiers):
+		return "speed modifier"
+	}
+	return ""
+}
+
+// Merge returns f with every filter set in override replacing the one of f
+func (f Filters) Merge(override Filters) Filters {
+	if override.MinStars != 0 {
+		f.MinStars = override.MinStars
+	}
+	if override.MaxStars != 0 {
+		f.MaxStars = override.MaxStars
+	}
+	if override.MinNJS != 0 {
+		f.MinNJS = override.MinNJS
+	}
+	if override.MaxNJS != 0 {
+		f.MaxNJS = override.MaxNJS
+	}
+	if override.Difficulties != nil {
+		f.Difficulties = override.Difficulties
+	}
+	if override.Characteristics != nil {
+		f.Characteristics = override.Characteristics
+	}
+	if override.Since != nil {
+		f.Since = override.Since
+	}
+	if override.Until != nil {
+		f.Until = override.Until
+	}
+	if override.MinAccuracy != 0 {
+		f.MinAccuracy = override.MinAccuracy
+	}
+	f.ExcludeFailed = f.ExcludeFailed || override.ExcludeFailed
+	f.ExcludePaused = f.ExcludePaused || override.ExcludePaused
+	f.ExcludeSpeedMods = f.ExcludeSpeedMods || override.ExcludeSpeedMods
+	if override.AllowHashes != nil {
+		f.AllowHashes = override.AllowHashes
+	}
+	if override.DenyHashes != nil {
+		f.DenyHashes = override.DenyHashes
+	}
+	return f
+}
+
+// HasSpeedModifier reports whether a comma separated modifier list contains SF, FS or SS
+func HasSpeedModifier(modifiers string) bool {
+	for _, m := range strings.Split(modifiers, ",") {
+		for _, speed := range speedModifiers {
+			if strings.EqualFold(strings.TrimSpace(m), speed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseRange parses "min-max", "min-" or "-max" into its bounds, 0 meaning unbounded
+func ParseRange(s string) (min float64, max float64, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	lower, upper, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q, expected min-max", s)
+	}
+	if lower != "" {
+		if min, err = strconv.ParseFloat(lower, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+	}
+	if upper != "" {
+		if max, err = strconv.ParseFloat(upper, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+	}
+	if min < 0 || max < 0 || (max > 0 && min > max) {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	return min, max, nil
+}
+
+// ParseDifficulties parses a comma separated list of difficulty names
+func ParseDifficulties(s string) ([]string, error) {
+	var res []string
+	for _, name := range splitList(s) {
+		found := false
+		for _, d := range utils.BLDifficulties {
+			if strings.EqualFold(d, name) {
+				res = append(res, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid difficulty %q, expected one of %s", name, strings.Join(utils.BLDifficulties, ", "))
+		}
+	}
+	return res, nil
+}
+
+// ParseCharacteristics parses a comma separated list of characteristics like Standard or OneSaber
+func ParseCharacteristics(s string) ([]string, error) {
+	var res []string
+	for _, name := range splitList(s) {
+		found := false
+		for _, c := range utils.BLCharacteristics {
+			if strings.EqualFold(c, name) {
+				res = append(res, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid characteristic %q, expected one of %s", name, strings.Join(utils.BLCharacteristics, ", "))
+		}
+	}
+	return res, nil
+}
+
+// ParseDate parses a date (2006-01-02) or a RFC 3339 timestamp, an empty string gives nil
+func ParseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+}
+
+// ParseAccuracy accepts accuracies both as fraction (0.95) and percentage (95)
+func ParseAccuracy(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	acc, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || acc < 0 || acc > 100 {
+		return 0, fmt.Errorf("invalid accuracy %q", s)
+	}
+	if acc > 1 {
+		acc /= 100
+	}
+	return acc, nil
+}
+
+// ParseHashes parses a comma separated list of map hashes, or reads one hash per line from a file given as @path
+func ParseHashes(s string) ([]string, error) {
+	if path, ok := strings.CutPrefix(s, "@"); ok {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s = strings.ReplaceAll(string(bytes), "\n", ",")
+	}
+	return splitList(s), nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(s string) *time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return &t
+}
+
+func TestFiltersReject(t *testing.T) {
+	play := Play{
+		Hash:           "ABC",
+		Difficulty:     "ExpertPlus",
+		Characteristic: "Standard",
+		Stars:          6,
+		NJS:            18,
+		Time:           *date("2025-06-01"),
+		Accuracy:       0.95,
+		Won:            true,
+		Modifiers:      "NF,GN",
+	}
+
+	tests := []struct {
+		name    string
+		filters Filters
+		edit    func(p *Play)
+		want    string
+	}{
+		{name: "no filters", want: ""},
+		{name: "all filters passed", filters: Filters{
+			MinStars: 5, MaxStars: 7, MinNJS: 16, MaxNJS: 20,
+			Difficulties: []string{"expertplus"}, Characteristics: []string{"standard"},
+			Since: date("2025-01-01"), Until: date("2026-01-01"), MinAccuracy: 0.9,
+			ExcludeFailed: true, ExcludePaused: true, ExcludeSpeedMods: true,
+			AllowHashes: []string{"abc"},
+		}, want: ""},
+		{name: "denied hash", filters: Filters{DenyHashes: []string{"abc"}}, want: "map hash"},
+		{name: "hash not allowed", filters: Filters{AllowHashes: []string{"DEF"}}, want: "map hash"},
+		{name: "deny wins over allow", filters: Filters{AllowHashes: []string{"ABC"}, DenyHashes: []string{"ABC"}}, want: "map hash"},
+		{name: "difficulty", filters: Filters{Difficulties: []string{"Expert"}}, want: "difficulty"},
+		{name: "characteristic", filters: Filters{Characteristics: []string{"OneSaber"}}, want: "characteristic"},
+		{name: "below min stars", filters: Filters{MinStars: 7}, want: "stars"},
+		{name: "above max stars", filters: Filters{MaxStars: 5}, want: "stars"},
+		{name: "stars bounds inclusive", filters: Filters{MinStars: 6, MaxStars: 6}, want: ""},
+		{name: "below min njs", filters: Filters{MinNJS: 19}, want: "njs"},
+		{name: "above max njs", filters: Filters{MaxNJS: 17}, want: "njs"},
+		{name: "before since", filters: Filters{Since: date("2025-07-01")}, want: "date"},
+		{name: "after until", filters: Filters{Until: date("2025-05-01")}, want: "date"},
+		{name: "accuracy", filters: Filters{MinAccuracy: 0.96}, want: "accuracy"},
+		{name: "failed", filters: Filters{ExcludeFailed: true}, edit: func(p *Play) { p.Won = false }, want: "failed"},
+		{name: "failed kept", edit: func(p *Play) { p.Won = false }, want: ""},
+		{name: "paused", filters: Filters{ExcludePaused: true}, edit: func(p *Play) { p.Pauses = 1 }, want: "paused"},
+		{name: "speed modifier", filters: Filters{ExcludeSpeedMods: true}, edit: func(p *Play) { p.Modifiers = "NF, fs" }, want: "speed modifier"},
+		{name: "first reason", filters: Filters{MinStars: 7, MinNJS: 19}, want: "stars"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := play
+			if tt.edit != nil {
+				tt.edit(&p)
+			}
+			if got := tt.filters.Reject(p); got != tt.want {
+				t.Errorf("Reject() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFiltersMerge(t *testing.T) {
+	// the filters of a dataset loaded with --from, merged with the ones given again on the command line
+	dataset := Filters{
+		MinStars:      4,
+		MaxNJS:        22,
+		Difficulties:  []string{"Expert", "ExpertPlus"},
+		Since:         date("2025-01-01"),
+		MinAccuracy:   0.9,
+		ExcludeFailed: true,
+		DenyHashes:    []string{"ABC"},
+	}
+
+	tests := []struct {
+		name     string
+		base     Filters
+		override Filters
+		want     Filters
+	}{
+		{name: "nothing given keeps the dataset filters", base: dataset, want: dataset},
+		{name: "nothing in the dataset", override: dataset, want: dataset},
+		{
+			name:     "given filters replace the ones of the dataset",
+			base:     dataset,
+			override: Filters{MinStars: 6, Difficulties: []string{"ExpertPlus"}, Since: date("2025-06-01"), DenyHashes: []string{"DEF"}},
+			want: Filters{
+				MinStars:      6,
+				MaxNJS:        22,
+				Difficulties:  []string{"ExpertPlus"},
+				Since:         date("2025-06-01"),
+				MinAccuracy:   0.9,
+				ExcludeFailed: true,
+				DenyHashes:    []string{"DEF"},
+			},
+		},
+		{
+			name:     "new filters are added",
+			base:     dataset,
+			override: Filters{MaxStars: 8, MinNJS: 14, Characteristics: []string{"Standard"}, Until: date("2026-01-01"), AllowHashes: []string{"GHI"}},
+			want: Filters{
+				MinStars:        4,
+				MaxStars:        8,
+				MinNJS:          14,
+				MaxNJS:          22,
+				Difficulties:    []string{"Expert", "ExpertPlus"},
+				Characteristics: []string{"Standard"},
+				Since:           date("2025-01-01"),
+				Until:           date("2026-01-01"),
+				MinAccuracy:     0.9,
+				ExcludeFailed:   true,
+				AllowHashes:     []string{"GHI"},
+				DenyHashes:      []string{"ABC"},
+			},
+		},
+		{
+			// a flag can't be given as false, so exclusions are only ever added
+			name:     "exclusions add up",
+			base:     Filters{ExcludeFailed: true},
+			override: Filters{ExcludePaused: true, ExcludeSpeedMods: true},
+			want:     Filters{ExcludeFailed: true, ExcludePaused: true, ExcludeSpeedMods: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.base.Merge(tt.override); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,33 +1,150 @@
 package models
 
 import (
-	"log/slog"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 )
 
+// MaxCount is the most scores a single run may fetch, to keep runs and API usage reasonable
+const MaxCount = 2000
+
 type Settings struct {
-	Count  int
-	Sort   string
-	Ranked bool
+	Count  int    `json:"count"`
+	Sort   string `json:"sort"`
+	Ranked bool   `json:"ranked"`
+
+	Filters Filters `json:"filters"`
+}
+
+// NewSettings creates validated settings, see Validate
+func NewSettings(count int, sort string, ranked bool, filters Filters) (Settings, error) {
+	s := Settings{
+		Count:   count,
+		Sort:    sort,
+		Ranked:  ranked,
+		Filters: filters,
+	}
+	var err error
+	if s.Sort, err = ParseSort(sort); err != nil {
+		return s, err
+	}
+	return s, s.Validate()
+}
+
+// Validate reports the first setting that is out of range
+func (s Settings) Validate() error {
+	if s.Count <= 0 || s.Count > MaxCount {
+		return fmt.Errorf("invalid score count %d, must be between 1 and %d", s.Count, MaxCount)
+	}
+	if _, err := ParseSort(s.Sort); err != nil {
+		return err
+	}
+
+	f := s.Filters
+	switch {
+	case f.MinStars < 0 || f.MaxStars < 0 || (f.MaxStars > 0 && f.MinStars > f.MaxStars):
+		return fmt.Errorf("invalid star range %g-%g", f.MinStars, f.MaxStars)
+	case f.MinNJS < 0 || f.MaxNJS < 0 || (f.MaxNJS > 0 && f.MinNJS > f.MaxNJS):
+		return fmt.Errorf("invalid NJS range %g-%g", f.MinNJS, f.MaxNJS)
+	case f.MinAccuracy < 0 || f.MinAccuracy > 1:
+		return fmt.Errorf("invalid minimum accuracy %g, must be between 0 and 1", f.MinAccuracy)
+	case f.Since != nil && f.Until != nil && f.Since.After(*f.Until):
+		return errors.New("invalid date window, since is after until")
+	}
+	return nil
+}
+
+// Summary describes in one line which plays the settings select
+func (s Settings) Summary() string {
+	parts := []string{fmt.Sprintf("%s %d scores", s.Sort, s.Count)}
+	if s.Ranked {
+		parts = append(parts, "ranked only")
+	} else {
+		parts = append(parts, "ranked and unranked")
+	}
+
+	f := s.Filters
+	if r := formatRange(f.MinStars, f.MaxStars); r != "" {
+		parts = append(parts, "stars "+r)
+	}
+	if r := formatRange(f.MinNJS, f.MaxNJS); r != "" {
+		parts = append(parts, "NJS "+r)
+	}
+	if len(f.Difficulties) > 0 {
+		parts = append(parts, "difficulties "+strings.Join(f.Difficulties, "/"))
+	}
+	if len(f.Characteristics) > 0 {
+		parts = append(parts, "characteristics "+strings.Join(f.Characteristics, "/"))
+	}
+	if f.Since != nil {
+		parts = append(parts, "since "+f.Since.Format("2006-01-02"))
+	}
+	if f.Until != nil {
+		parts = append(parts, "until "+f.Until.Format("2006-01-02"))
+	}
+	if f.MinAccuracy > 0 {
+		parts = append(parts, fmt.Sprintf("accuracy >= %.2f%%", f.MinAccuracy*100))
+	}
+	if f.ExcludeFailed {
+		parts = append(parts, "no failed plays")
+	}
+	if f.ExcludePaused {
+		parts = append(parts, "no paused plays")
+	}
+	if f.ExcludeSpeedMods {
+		parts = append(parts, "no speed modifiers")
+	}
+	if len(f.AllowHashes) > 0 {
+		parts = append(parts, fmt.Sprintf("%d allowed maps", len(f.AllowHashes)))
+	}
+	if len(f.DenyHashes) > 0 {
+		parts = append(parts, fmt.Sprintf("%d denied maps", len(f.DenyHashes)))
+	}
+	return strings.Join(parts, ", ")
 }
 
-func (s *Settings) SetRanked(c string) {
-	s.Ranked = c != "false"
+func formatRange(min, max float64) string {
+	switch {
+	case min > 0 && max > 0:
+		return fmt.Sprintf("%g-%g", min, max)
+	case min > 0:
+		return fmt.Sprintf(">= %g", min)
+	case max > 0:
+		return fmt.Sprintf("<= %g", max)
+	}
+	return ""
 }
-func (s *Settings) SetSort(c string) {
-	switch c {
-	case "1":
-		s.Sort = "top"
-	case "2":
-		s.Sort = "recent"
-	default:
-		s.Sort = "top"
+
+// ParseCount parses a score count as entered by the user
+func ParseCount(c string) (int, error) {
+	count, err := strconv.Atoi(strings.TrimSpace(c))
+	if err != nil {
+		return 0, fmt.Errorf("invalid score count %q, expected a number", c)
+	}
+	if count <= 0 || count > MaxCount {
+		return 0, fmt.Errorf("invalid score count %d, must be between 1 and %d", count, MaxCount)
 	}
+	return count, nil
 }
-func (s *Settings) SetCount(c string) {
-	lCount, err := strconv.Atoi(c)
+
+// ParseRanked parses a ranked status as entered by the user
+func ParseRanked(c string) (bool, error) {
+	ranked, err := strconv.ParseBool(strings.TrimSpace(c))
 	if err != nil {
-		s.Count = 100
+		return false, fmt.Errorf("invalid ranked status %q, expected true or false", c)
+	}
+	return ranked, nil
+}
+
+// ParseSort normalizes a sort order given either by name or by its prompt number
+func ParseSort(c string) (string, error) {
+	switch strings.TrimSpace(c) {
+	case "1", "top":
+		return "top", nil
+	case "2", "recent":
+		return "recent", nil
 	}
-	s.Count = lCount
+	return "", fmt.Errorf("invalid sort order %q, expected top or recent", c)
 }
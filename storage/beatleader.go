@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"strings"
+)
+
+const blScoresParams = "?sortBy=%s&order=desc&page=%d&count=%d&leaderboardContext=general&type=%s"
+const blPlayerSearchUrl = "https://api.beatleader.com/players?search=%s&count=10"
+
+// beatleader scores > leaderboard hash + difficulty + mode > bl /leaderboard/hash/diff/mode > stats
+
+// beatLeaderSource lists scores directly on BeatLeader, so players without ScoreSaber profile are supported
+type beatLeaderSource struct {
+	client *utils.Client
+}
+
+func (s *beatLeaderSource) Name() string {
+	return "BeatLeader"
+}
+
+func (s *beatLeaderSource) ResolvePlayer(ctx context.Context, query string) ([]*utils.SSPlayer, error) {
+	return ResolveBLPlayer(ctx, s.client, query)
+}
+
+func (s *beatLeaderSource) ListScores(ctx context.Context, playerId string, settings models.Settings) ([]ScoreRef, error) {
+	blScores, err := fetchAllBLScores(ctx, s.client, playerId, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []ScoreRef
+	for i := range blScores {
+		lead := blScores[i].Leaderboard
+		if lead == nil {
+			continue
+		}
+		if settings.Filters.Since != nil && blScores[i].Time().Before(*settings.Filters.Since) {
+			continue
+		}
+		refs = append(refs, ScoreRef{
+			SongName:   lead.Song.Name,
+			Hash:       lead.Song.Hash,
+			Difficulty: lead.Difficulty.DifficultyName,
+			Mode:       lead.Difficulty.ModeName,
+			Time:       blScores[i].Time(),
+			Score:      &blScores[i],
+		})
+	}
+	return refs, nil
+}
+
+func (s *beatLeaderSource) FetchLeaderboard(ctx context.Context, ref ScoreRef) (*utils.BLLeaderboard, error) {
+	return utils.Fetch[utils.BLLeaderboard](ctx, s.client, fmt.Sprintf(blLeaderboardUrl, ref.Hash, ref.Difficulty, ref.Mode))
+}
+
+func (s *beatLeaderSource) FetchPlayStats(ctx context.Context, playerId string, ref ScoreRef) (*utils.BLScore, *utils.ScoreStats, error) {
+	blStats, err := utils.Fetch[utils.ScoreStats](ctx, s.client, fmt.Sprintf(statsUrl, ref.Score.Id))
+	if err != nil {
+		return nil, nil, &skipError{reason: SkipMissingStats, err: err}
+	}
+	return ref.Score, blStats, nil
+}
+
+func fetchAllBLScores(ctx context.Context, client *utils.Client, playerId string, settings models.Settings) ([]utils.BLScore, error) {
+	const maxScoresPerPage = 100
+
+	sortBy := "pp"
+	if settings.Sort == "recent" {
+		sortBy = "date"
+	}
+	scoreType := "all"
+	if settings.Ranked {
+		scoreType = "ranked"
+	}
+
+	// pages are addressed by number, so every page must have the same size for them not to overlap
+	var scores []utils.BLScore
+	for page := 1; len(scores) < settings.Count; page++ {
+		u := fmt.Sprintf(utils.BeatLeaderScoresUrl, playerId) + fmt.Sprintf(blScoresParams, sortBy, page, maxScoresPerPage, scoreType)
+		pageScores, err := utils.Fetch[utils.BLScoreResponse](ctx, client, u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch page %d: %w", page, err)
+		}
+
+		scores = append(scores, pageScores.Data...)
+		if len(pageScores.Data) < maxScoresPerPage {
+			break
+		}
+		if sortBy == "date" && pageScores.Data[len(pageScores.Data)-1].Time().Before(since(settings)) {
+			break
+		}
+	}
+
+	if len(scores) > settings.Count {
+		scores = scores[:settings.Count]
+	}
+	return scores, nil
+}
+
+// ResolveBLPlayer is like ResolvePlayer, but looks players up on BeatLeader only
+func ResolveBLPlayer(ctx context.Context, client *utils.Client, query string) ([]*utils.SSPlayer, error) {
+	query = strings.TrimSpace(query)
+
+	id := query
+	if u, err := url.Parse(query); err == nil && u.Host != "" {
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if !strings.HasPrefix(strings.TrimPrefix(u.Host, "www."), "beatleader.") || len(segments) < 2 || segments[0] != "u" {
+			return nil, fmt.Errorf("unsupported profile url %q, expected a BeatLeader profile", query)
+		}
+		id = segments[1]
+	}
+
+	// ids and aliases are answered directly, everything else is searched for by name
+	player, err := utils.Fetch[utils.BLPlayer](ctx, client, fmt.Sprintf(blPlayerUrl, url.PathEscape(id)))
+	if err == nil {
+		return []*utils.SSPlayer{player.AsPlayer()}, nil
+	}
+	if !utils.IsNotFound(err) || id != query {
+		return nil, err
+	}
+
+	res, err := utils.Fetch[utils.BLPlayersResponse](ctx, client, fmt.Sprintf(blPlayerSearchUrl, url.QueryEscape(query)))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("no BeatLeader player found for %q", query)
+	}
+
+	players := make([]*utils.SSPlayer, len(res.Data))
+	for i := range res.Data {
+		players[i] = res.Data[i].AsPlayer()
+		if strings.EqualFold(res.Data[i].Name, query) {
+			return []*utils.SSPlayer{players[i]}, nil
+		}
+	}
+	return players, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DatasetVersion is increased whenever the layout of Dataset changes incompatibly
+const DatasetVersion = 2
+
+const DatasetDir = "_cache/datasets"
+
+// Dataset is a snapshot of fetched plays, written by the fetch command and read back by generate
+type Dataset struct {
+	Version   int                  `json:"version"`
+	Source    string               `json:"source"`
+	Player    *utils.SSPlayer      `json:"player"`
+	Settings  models.Settings      `json:"settings"`
+	FetchedAt time.Time            `json:"fetchedAt"`
+	Results   []*utils.StatsResult `json:"results"`
+}
+
+func NewDataset(source string, player *utils.SSPlayer, settings models.Settings, results []*utils.StatsResult) *Dataset {
+	return &Dataset{
+		Version:   DatasetVersion,
+		Source:    source,
+		Player:    player,
+		Settings:  settings,
+		FetchedAt: time.Now().UTC(),
+		Results:   results,
+	}
+}
+
+// SaveDataset writes ds into dir and returns the path of the created file
+func SaveDataset(dir string, ds *Dataset) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	bytes, err := json.Marshal(ds)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s-%s.json", ds.Player.Id, ds.Settings.Sort, ds.FetchedAt.Format("20060102-150405")))
+	if err = os.WriteFile(path, bytes, 0666); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func LoadDataset(path string) (*Dataset, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ds Dataset
+	if err = json.Unmarshal(bytes, &ds); err != nil {
+		return nil, fmt.Errorf("failed to parse dataset %s: %w", path, err)
+	}
+	if ds.Version != DatasetVersion {
+		return nil, fmt.Errorf("dataset %s has version %d, expected %d; fetch it again", path, ds.Version, DatasetVersion)
+	}
+	if ds.Player == nil {
+		return nil, fmt.Errorf("dataset %s has no player", path)
+	}
+
+	return &ds, nil
+}
+
+// LatestDataset returns the path of the most recent dataset of playerId in dir
+func LatestDataset(dir string, playerId string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, playerId+"-*.json"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", errors.New("no dataset found for player " + playerId + " in " + dir + ", run fetch first")
+	}
+
+	// file names end with the fetch timestamp, so the newest one sorts last
+	sort.Slice(matches, func(i, j int) bool {
+		return timestampOf(matches[i]) < timestampOf(matches[j])
+	})
+	return matches[len(matches)-1], nil
+}
+
+func timestampOf(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	parts := strings.Split(name, "-")
+	if len(parts) < 2 {
+		return name
+	}
+	return strings.Join(parts[len(parts)-2:], "-")
+}
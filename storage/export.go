@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"playerAnalyzer/utils"
+	"strconv"
+	"time"
+)
+
+// Export formats
+const (
+	FormatCSV     = "csv"
+	FormatColumns = "columns"
+)
+
+// ColumnsVersion is increased whenever the layout of the columnar export changes incompatibly
+const ColumnsVersion = 1
+
+// Column is a single flattened property of a play
+type Column struct {
+	Name string
+	// Type is one of string, int, float, bool or time
+	Type  string
+	value func(p *ExportPlay) any
+}
+
+// ExportPlay is a play together with the player it belongs to
+type ExportPlay struct {
+	Player *utils.SSPlayer
+	*utils.StatsResult
+}
+
+func (p *ExportPlay) score() *utils.BLScore {
+	if p.Score == nil {
+		return &utils.BLScore{}
+	}
+	return p.Score
+}
+
+// ExportColumns are the columns of every export, in order
+var ExportColumns = []Column{
+	{"player_id", "string", func(p *ExportPlay) any { return p.Player.Id }},
+	{"player_name", "string", func(p *ExportPlay) any { return p.Player.Name }},
+	{"score_id", "int", func(p *ExportPlay) any { return p.score().Id }},
+	{"time", "time", func(p *ExportPlay) any { return p.score().Time() }},
+	{"song_name", "string", func(p *ExportPlay) any { return p.BLLead.Song.Name }},
+	{"mapper", "string", func(p *ExportPlay) any { return p.BLLead.Song.Mapper }},
+	{"hash", "string", func(p *ExportPlay) any { return p.BLLead.Song.Hash }},
+	{"difficulty", "string", func(p *ExportPlay) any { return p.BLLead.Difficulty.DifficultyName }},
+	{"mode", "string", func(p *ExportPlay) any { return p.BLLead.Difficulty.ModeName }},
+	{"ranked", "bool", func(p *ExportPlay) any { return p.BLLead.Difficulty.Status == blStatusRanked }},
+	{"stars", "float", func(p *ExportPlay) any { return p.BLLead.Difficulty.Stars }},
+	{"njs", "float", func(p *ExportPlay) any { return p.BLLead.Difficulty.Njs }},
+	{"nps", "float", func(p *ExportPlay) any { return p.BLLead.Difficulty.Nps }},
+	{"bpm", "float", func(p *ExportPlay) any { return p.BLLead.Song.Bpm }},
+	{"notes", "int", func(p *ExportPlay) any { return p.BLLead.Difficulty.Notes }},
+	{"jd", "float", func(p *ExportPlay) any { return p.Stats.WinTracker.JumpDistance }},
+	{"accuracy", "float", func(p *ExportPlay) any { return p.score().Accuracy }},
+	{"pp", "float", func(p *ExportPlay) any { return p.score().Pp }},
+	{"modifiers", "string", func(p *ExportPlay) any { return p.score().Modifiers }},
+	{"full_combo", "bool", func(p *ExportPlay) any { return p.score().FullCombo }},
+	{"won", "bool", func(p *ExportPlay) any { return p.Stats.WinTracker.Won }},
+	{"end_time", "float", func(p *ExportPlay) any { return p.Stats.WinTracker.EndTime }},
+	{"pauses", "int", func(p *ExportPlay) any { return p.Stats.WinTracker.NbOfPause }},
+	{"pause_duration", "float", func(p *ExportPlay) any { return p.Stats.WinTracker.TotalPauseDuration }},
+	{"acc_left", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.AccLeft }},
+	{"acc_right", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.AccRight }},
+	{"fc_acc", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.FcAcc }},
+	{"left_preswing", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.LeftPreswing }},
+	{"right_preswing", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.RightPreswing }},
+	{"left_postswing", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.LeftPostswing }},
+	{"right_postswing", "float", func(p *ExportPlay) any { return p.Stats.AccuracyTracker.RightPostswing }},
+	{"left_timing", "float", func(p *ExportPlay) any { return p.Stats.HitTracker.LeftTiming }},
+	{"right_timing", "float", func(p *ExportPlay) any { return p.Stats.HitTracker.RightTiming }},
+	{"left_miss", "int", func(p *ExportPlay) any { return p.Stats.HitTracker.LeftMiss }},
+	{"right_miss", "int", func(p *ExportPlay) any { return p.Stats.HitTracker.RightMiss }},
+	{"left_bad_cuts", "int", func(p *ExportPlay) any { return p.Stats.HitTracker.LeftBadCuts }},
+	{"right_bad_cuts", "int", func(p *ExportPlay) any { return p.Stats.HitTracker.RightBadCuts }},
+	{"max_combo", "int", func(p *ExportPlay) any { return p.Stats.HitTracker.MaxCombo }},
+	{"head_height", "float", func(p *ExportPlay) any { return p.Stats.WinTracker.AverageHeight }},
+	{"head_x", "float", func(p *ExportPlay) any { return p.Stats.WinTracker.AverageHeadPosition.X }},
+	{"head_z", "float", func(p *ExportPlay) any { return p.Stats.WinTracker.AverageHeadPosition.Z }},
+}
+
+// ExportPlays pairs every result with player, skipping results without leaderboard or stats
+func ExportPlays(player *utils.SSPlayer, results []*utils.StatsResult) []*ExportPlay {
+	plays := make([]*ExportPlay, 0, len(results))
+	for _, r := range results {
+		if r.BLLead == nil || r.Stats == nil {
+			continue
+		}
+		plays = append(plays, &ExportPlay{Player: player, StatsResult: r})
+	}
+	return plays
+}
+
+// WriteExport writes plays to w in the given format
+func WriteExport(w io.Writer, format string, plays []*ExportPlay) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, plays)
+	case FormatColumns:
+		return writeColumns(w, plays)
+	}
+	return fmt.Errorf("invalid export format %q, expected %s or %s", format, FormatCSV, FormatColumns)
+}
+
+func writeCSV(w io.Writer, plays []*ExportPlay) error {
+	cw := csv.NewWriter(w)
+
+	header := make([]string, len(ExportColumns))
+	for i, c := range ExportColumns {
+		header[i] = c.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(ExportColumns))
+	for _, p := range plays {
+		for i, c := range ExportColumns {
+			record[i] = formatCell(c.value(p))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatCell(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// columnsFile is the columnar export: one array of values per column, so notebooks can load single columns cheaply
+type columnsFile struct {
+	Version int           `json:"version"`
+	Rows    int           `json:"rows"`
+	Columns []columnsData `json:"columns"`
+}
+
+type columnsData struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Values []any  `json:"values"`
+}
+
+func writeColumns(w io.Writer, plays []*ExportPlay) error {
+	file := columnsFile{
+		Version: ColumnsVersion,
+		Rows:    len(plays),
+		Columns: make([]columnsData, len(ExportColumns)),
+	}
+	for i, c := range ExportColumns {
+		values := make([]any, len(plays))
+		for j, p := range plays {
+			v := c.value(p)
+			if t, ok := v.(time.Time); ok {
+				v = formatCell(t)
+			}
+			values[j] = v
+		}
+		file.Columns[i] = columnsData{Name: c.Name, Type: c.Type, Values: values}
+	}
+
+	return json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importColumns are the exported columns read back by LoadFile, everything else is ignored.
+// Only njs and jd are required, so hand-curated files can be as small as two columns.
+var importColumns = map[string]func(p *ExportPlay, v string) error{
+	"player_id":   func(p *ExportPlay, v string) error { p.Player.Id = v; return nil },
+	"player_name": func(p *ExportPlay, v string) error { p.Player.Name = v; return nil },
+	"score_id":    func(p *ExportPlay, v string) error { return parseCell(v, &p.Score.Id) },
+	"time": func(p *ExportPlay, v string) error {
+		if v == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		p.Score.Timeset = strconv.FormatInt(t.Unix(), 10)
+		return err
+	},
+	"song_name":  func(p *ExportPlay, v string) error { p.BLLead.Song.Name = v; return nil },
+	"hash":       func(p *ExportPlay, v string) error { p.BLLead.Song.Hash = v; return nil },
+	"difficulty": func(p *ExportPlay, v string) error { p.BLLead.Difficulty.DifficultyName = v; return nil },
+	"mode":       func(p *ExportPlay, v string) error { p.BLLead.Difficulty.ModeName = v; return nil },
+	"stars":      func(p *ExportPlay, v string) error { return parseCell(v, &p.BLLead.Difficulty.Stars) },
+	"njs":        func(p *ExportPlay, v string) error { return parseCell(v, &p.BLLead.Difficulty.Njs) },
+	"nps":        func(p *ExportPlay, v string) error { return parseCell(v, &p.BLLead.Difficulty.Nps) },
+	"jd":         func(p *ExportPlay, v string) error { return parseCell(v, &p.Stats.WinTracker.JumpDistance) },
+	"accuracy":   func(p *ExportPlay, v string) error { return parseCell(v, &p.Score.Accuracy) },
+	"pp":         func(p *ExportPlay, v string) error { return parseCell(v, &p.Score.Pp) },
+	"modifiers":  func(p *ExportPlay, v string) error { p.Score.Modifiers = v; return nil },
+	"won":        func(p *ExportPlay, v string) error { return parseCell(v, &p.Stats.WinTracker.Won) },
+	"pauses":     func(p *ExportPlay, v string) error { return parseCell(v, &p.Stats.WinTracker.NbOfPause) },
+}
+
+func parseCell[T int | float64 | bool](v string, dst *T) error {
+	if v == "" {
+		return nil
+	}
+	var err error
+	switch d := any(dst).(type) {
+	case *int:
+		*d, err = strconv.Atoi(v)
+	case *float64:
+		*d, err = strconv.ParseFloat(v, 64)
+	case *bool:
+		*d, err = strconv.ParseBool(v)
+	}
+	return err
+}
+
+// LoadFile loads a dataset written by fetch, or a csv or columnar file written by export
+func LoadFile(path string) (*Dataset, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		if rows, err = csv.NewReader(bytes.NewReader(bts)).ReadAll(); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	default:
+		var probe struct {
+			Columns json.RawMessage `json:"columns"`
+		}
+		if err = json.Unmarshal(bts, &probe); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if probe.Columns == nil {
+			return LoadDataset(path)
+		}
+		if rows, err = columnsToRows(bts); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	plays, skipped, err := parseRows(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if skipped > 0 {
+		slog.Warn(fmt.Sprintf("Skipped %d rows of %s with an empty njs or jd", skipped, path))
+	}
+	return exportDataset(path, plays), nil
+}
+
+// columnsToRows turns a columnar export into a header row followed by one row per play
+func columnsToRows(bts []byte) ([][]string, error) {
+	var file columnsFile
+	if err := json.Unmarshal(bts, &file); err != nil {
+		return nil, err
+	}
+	if file.Version != ColumnsVersion {
+		return nil, fmt.Errorf("columnar file has version %d, expected %d", file.Version, ColumnsVersion)
+	}
+
+	rows := make([][]string, file.Rows+1)
+	for i := range rows {
+		rows[i] = make([]string, len(file.Columns))
+	}
+	for j, c := range file.Columns {
+		if len(c.Values) != file.Rows {
+			return nil, fmt.Errorf("column %s has %d values, expected %d", c.Name, len(c.Values), file.Rows)
+		}
+		rows[0][j] = c.Name
+		for i, v := range c.Values {
+			if v != nil {
+				rows[i+1][j] = formatCell(v)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// parseRows parses the rows following the header into plays, rows missing the njs or jd are skipped and counted
+func parseRows(rows [][]string) ([]*ExportPlay, int, error) {
+	if len(rows) == 0 {
+		return nil, 0, errors.New("file is empty")
+	}
+
+	header := rows[0]
+	found := make(map[string]bool)
+	for _, name := range header {
+		found[strings.TrimSpace(name)] = true
+	}
+	for _, required := range []string{"njs", "jd"} {
+		if !found[required] {
+			return nil, 0, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	plays := make([]*ExportPlay, 0, len(rows)-1)
+	skipped := 0
+	for i, row := range rows[1:] {
+		p := &ExportPlay{
+			Player: &utils.SSPlayer{},
+			StatsResult: &utils.StatsResult{
+				BLLead: &utils.BLLeaderboard{},
+				Score:  &utils.BLScore{},
+				Stats:  &utils.ScoreStats{},
+			},
+		}
+		// hand-curated rows are usually successful plays, an explicit won column overrides this
+		p.Stats.WinTracker.Won = true
+
+		// an empty cell would otherwise be trained on as 0
+		given := 0
+		for j, name := range header {
+			name = strings.TrimSpace(name)
+			set, ok := importColumns[name]
+			if !ok || j >= len(row) {
+				continue
+			}
+			value := strings.TrimSpace(row[j])
+			if value != "" && (name == "njs" || name == "jd") {
+				given++
+			}
+			if err := set(p, value); err != nil {
+				return nil, 0, fmt.Errorf("row %d, column %s: %w", i+2, name, err)
+			}
+		}
+		if given < 2 {
+			skipped++
+			continue
+		}
+		plays = append(plays, p)
+	}
+	return plays, skipped, nil
+}
+
+// exportDataset wraps imported plays into a dataset, naming the player after the file if the rows don't say
+func exportDataset(path string, plays []*ExportPlay) *Dataset {
+	player := &utils.SSPlayer{}
+	if len(plays) > 0 {
+		player = plays[0].Player
+	}
+	if player.Id == "" {
+		player.Id = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if player.Name == "" {
+		player.Name = player.Id
+	}
+
+	ds := &Dataset{
+		Version: DatasetVersion,
+		Source:  "file:" + path,
+		Player:  player,
+		Results: make([]*utils.StatsResult, len(plays)),
+	}
+	for i, p := range plays {
+		ds.Results[i] = p.StatsResult
+	}
+	ds.Settings.Count = settingsCount(len(plays))
+	ds.Settings.Sort = "top"
+	if fi, err := os.Stat(path); err == nil {
+		ds.FetchedAt = fi.ModTime().UTC()
+	}
+	return ds
+}
+
+// MergeDatasets combines datasets, e.g. of several accounts of the same player, into one.
+// Plays contained in several of them are only kept once; settings are taken from the first dataset.
+func MergeDatasets(datasets []*Dataset) *Dataset {
+	if len(datasets) == 1 {
+		return datasets[0]
+	}
+
+	merged := &Dataset{
+		Version:  DatasetVersion,
+		Source:   "merged",
+		Settings: datasets[0].Settings,
+	}
+
+	var ids, names []string
+	seenPlayers := make(map[string]bool)
+	seenScores := make(map[int]bool)
+	for _, ds := range datasets {
+		if !seenPlayers[ds.Player.Id] {
+			seenPlayers[ds.Player.Id] = true
+			ids = append(ids, ds.Player.Id)
+			names = append(names, ds.Player.Name)
+		}
+		if ds.FetchedAt.After(merged.FetchedAt) {
+			merged.FetchedAt = ds.FetchedAt
+		}
+		for _, r := range ds.Results {
+			if r.Score != nil && r.Score.Id != 0 {
+				if seenScores[r.Score.Id] {
+					continue
+				}
+				seenScores[r.Score.Id] = true
+			}
+			merged.Results = append(merged.Results, r)
+		}
+	}
+
+	merged.Player = datasets[0].Player
+	if len(ids) > 1 {
+		merged.Player = &utils.SSPlayer{
+			Id:      strings.Join(ids, "+"),
+			Name:    strings.Join(names, " + "),
+			Country: datasets[0].Player.Country,
+		}
+	}
+	merged.Settings.Count = settingsCount(len(merged.Results))
+	return merged
+}
+
+// settingsCount is the score count recorded for plays not fetched by count, kept within what Settings accept
+func settingsCount(plays int) int {
+	return min(max(plays, 1), models.MaxCount)
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"testing"
+)
+
+func TestParseRows(t *testing.T) {
+	type play struct {
+		njs, jd float64
+		won     bool
+	}
+	tests := []struct {
+		name        string
+		rows        [][]string
+		want        []play
+		wantSkipped int
+		wantErr     bool
+	}{
+		{
+			// hand-curated rows without a won column count as passed
+			name: "njs and jd only",
+			rows: [][]string{{"njs", "jd"}, {"16", "18.5"}, {"20", "21"}},
+			want: []play{{16, 18.5, true}, {20, 21, true}},
+		},
+		{
+			name: "columns in any order with extras",
+			rows: [][]string{{"mapper", "jd", "won", " njs "}, {"someone", "18.5", "false", "16"}},
+			want: []play{{16, 18.5, false}},
+		},
+		{
+			name:        "empty njs or jd skips the row",
+			rows:        [][]string{{"njs", "jd", "won"}, {"16", "18.5", "true"}, {"", "19", "true"}, {"17", " ", "true"}, {"18", "20", ""}},
+			want:        []play{{16, 18.5, true}, {18, 20, true}},
+			wantSkipped: 2,
+		},
+		{
+			name:        "short row skipped",
+			rows:        [][]string{{"njs", "jd"}, {"16"}},
+			wantSkipped: 1,
+		},
+		{name: "missing jd column", rows: [][]string{{"njs", "accuracy"}, {"16", "0.9"}}, wantErr: true},
+		{name: "invalid number", rows: [][]string{{"njs", "jd"}, {"16", "far"}}, wantErr: true},
+		{name: "empty", rows: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plays, skipped, err := parseRows(tt.rows)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseRows() = %d plays, want an error", len(plays))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(plays) != len(tt.want) || skipped != tt.wantSkipped {
+				t.Fatalf("parseRows() = %d plays and %d skipped, want %d and %d", len(plays), skipped, len(tt.want), tt.wantSkipped)
+			}
+			for i, p := range plays {
+				got := play{p.BLLead.Difficulty.Njs, p.Stats.WinTracker.JumpDistance, p.Stats.WinTracker.Won}
+				if got != tt.want[i] {
+					t.Errorf("play %d = %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		// wantNJS are the njs of the loaded plays
+		wantNJS []float64
+		wantErr bool
+	}{
+		{name: "csv", file: "plays.csv", content: "njs,jd\n16,18.5\n,19\n20,21\n", wantNJS: []float64{16, 20}},
+		{
+			name:    "columnar",
+			file:    "plays.json",
+			content: `{"version": 1, "rows": 3, "columns": [{"name": "njs", "type": "float", "values": [16, null, 18]}, {"name": "jd", "type": "float", "values": [18.5, 19, 20]}]}`,
+			wantNJS: []float64{16, 18},
+		},
+		{
+			name:    "columnar of another version",
+			file:    "plays.json",
+			content: `{"version": 2, "rows": 0, "columns": [{"name": "njs", "values": []}, {"name": "jd", "values": []}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "column of another length",
+			file:    "plays.json",
+			content: `{"version": 1, "rows": 2, "columns": [{"name": "njs", "values": [16, 17]}, {"name": "jd", "values": [18]}]}`,
+			wantErr: true,
+		},
+		{name: "empty csv", file: "plays.csv", content: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			ds, err := LoadFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadFile() = %d plays, want an error", len(ds.Results))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ds.Results) != len(tt.wantNJS) {
+				t.Fatalf("LoadFile() = %d plays, want %d", len(ds.Results), len(tt.wantNJS))
+			}
+			for i, r := range ds.Results {
+				if r.BLLead.Difficulty.Njs != tt.wantNJS[i] {
+					t.Errorf("play %d has njs %v, want %v", i, r.BLLead.Difficulty.Njs, tt.wantNJS[i])
+				}
+			}
+			// the player is named after the file as the rows don't say
+			if ds.Player.Id != "plays" || ds.Player.Name != "plays" {
+				t.Errorf("player = %+v, want it named after the file", ds.Player)
+			}
+			if err = ds.Settings.Validate(); err != nil {
+				t.Errorf("settings of the loaded file are invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestMergeDatasets(t *testing.T) {
+	result := func(scoreId int, njs float64) *utils.StatsResult {
+		r := &utils.StatsResult{BLLead: &utils.BLLeaderboard{}, Score: &utils.BLScore{Id: scoreId}, Stats: &utils.ScoreStats{}}
+		r.BLLead.Difficulty.Njs = njs
+		return r
+	}
+	dataset := func(playerId string, results ...*utils.StatsResult) *Dataset {
+		return NewDataset("test", &utils.SSPlayer{Id: playerId, Name: "Player " + playerId}, models.Settings{Count: 3, Sort: "top"}, results)
+	}
+
+	tests := []struct {
+		name       string
+		datasets   []*Dataset
+		wantNJS    []float64
+		wantPlayer string
+	}{
+		{
+			name:       "single dataset is kept",
+			datasets:   []*Dataset{dataset("1", result(1, 16), result(2, 17))},
+			wantNJS:    []float64{16, 17},
+			wantPlayer: "1",
+		},
+		{
+			name:       "same score once",
+			datasets:   []*Dataset{dataset("1", result(1, 16), result(2, 17)), dataset("1", result(2, 17), result(3, 18))},
+			wantNJS:    []float64{16, 17, 18},
+			wantPlayer: "1",
+		},
+		{
+			// imported rows without score id can't be told apart, so they are all kept
+			name:       "rows without score id kept",
+			datasets:   []*Dataset{dataset("1", result(0, 16), result(0, 16)), dataset("1", result(0, 16))},
+			wantNJS:    []float64{16, 16, 16},
+			wantPlayer: "1",
+		},
+		{
+			name:       "accounts of the same player",
+			datasets:   []*Dataset{dataset("1", result(1, 16)), dataset("2", result(2, 17)), dataset("1", result(3, 18))},
+			wantNJS:    []float64{16, 17, 18},
+			wantPlayer: "1+2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := MergeDatasets(tt.datasets)
+
+			var njs []float64
+			for _, r := range merged.Results {
+				njs = append(njs, r.BLLead.Difficulty.Njs)
+			}
+			if len(njs) != len(tt.wantNJS) {
+				t.Fatalf("MergeDatasets() = plays with njs %v, want %v", njs, tt.wantNJS)
+			}
+			for i := range njs {
+				if njs[i] != tt.wantNJS[i] {
+					t.Fatalf("MergeDatasets() = plays with njs %v, want %v", njs, tt.wantNJS)
+				}
+			}
+			if merged.Player.Id != tt.wantPlayer {
+				t.Errorf("MergeDatasets() player = %q, want %q", merged.Player.Id, tt.wantPlayer)
+			}
+			if len(tt.datasets) > 1 && merged.Settings.Count != len(njs) {
+				t.Errorf("MergeDatasets() count = %d, want %d", merged.Settings.Count, len(njs))
+			}
+		})
+	}
+}
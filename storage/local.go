@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"sort"
+	"strings"
+)
+
+// blStatusRanked is the BeatLeader leaderboard status of ranked maps
+const blStatusRanked = 3
+
+// dirSource reads plays from the datasets written by fetch into a directory, without touching the network.
+// Datasets of the same player are merged, so several fetches (e.g. top and recent) complement each other.
+type dirSource struct {
+	dir string
+}
+
+func (s *dirSource) Name() string {
+	return "directory " + s.dir
+}
+
+// datasets loads all datasets in the directory, skipping files that are not readable datasets
+func (s *dirSource) datasets() ([]*Dataset, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Dataset
+	for _, path := range paths {
+		ds, err := LoadDataset(path)
+		if err != nil {
+			slog.Debug(err.Error())
+			continue
+		}
+		res = append(res, ds)
+	}
+	return res, nil
+}
+
+func (s *dirSource) ResolvePlayer(ctx context.Context, query string) ([]*utils.SSPlayer, error) {
+	datasets, err := s.datasets()
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.TrimSpace(query)
+	seen := make(map[string]bool)
+	var players []*utils.SSPlayer
+	for _, ds := range datasets {
+		if seen[ds.Player.Id] {
+			continue
+		}
+		if ds.Player.Id == query || strings.EqualFold(ds.Player.Name, query) {
+			return []*utils.SSPlayer{ds.Player}, nil
+		}
+		if strings.Contains(strings.ToLower(ds.Player.Name), strings.ToLower(query)) {
+			seen[ds.Player.Id] = true
+			players = append(players, ds.Player)
+		}
+	}
+
+	if len(players) == 0 {
+		return nil, fmt.Errorf("no dataset in %s matches player %q", s.dir, query)
+	}
+	return players, nil
+}
+
+func (s *dirSource) ListScores(ctx context.Context, playerId string, settings models.Settings) ([]ScoreRef, error) {
+	datasets, err := s.datasets()
+	if err != nil {
+		return nil, err
+	}
+
+	// the same play may be part of several datasets, the newest copy wins
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].FetchedAt.After(datasets[j].FetchedAt)
+	})
+	seen := make(map[string]bool)
+	var results []*utils.StatsResult
+	for _, ds := range datasets {
+		if ds.Player.Id != playerId {
+			continue
+		}
+		for _, r := range ds.Results {
+			if r.BLLead == nil || r.Stats == nil {
+				continue
+			}
+			key := r.BLLead.Id
+			if r.Score != nil {
+				key = fmt.Sprint(r.Score.Id)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			// datasets fetched with ranked only contain nothing else, older ones may lack the status
+			if settings.Ranked && !ds.Settings.Ranked && r.BLLead.Difficulty.Status != blStatusRanked {
+				continue
+			}
+			results = append(results, r)
+		}
+	}
+	if len(seen) == 0 {
+		return nil, errors.New("no dataset found for player " + playerId + " in " + s.dir)
+	}
+
+	return selectPlays(results, settings), nil
+}
+
+// selectPlays sorts locally stored plays like the APIs would, applies the date window and count of settings
+// and wraps them into refs that need no further fetching
+func selectPlays(results []*utils.StatsResult, settings models.Settings) []ScoreRef {
+	var selected []*utils.StatsResult
+	for _, r := range results {
+		if settings.Filters.Since != nil && (r.Score == nil || r.Score.Time().Before(*settings.Filters.Since)) {
+			continue
+		}
+		selected = append(selected, r)
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		a, b := selected[i].Score, selected[j].Score
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if settings.Sort == "recent" {
+			return a.Time().After(b.Time())
+		}
+		return a.Pp > b.Pp
+	})
+	if len(selected) > settings.Count {
+		selected = selected[:settings.Count]
+	}
+
+	refs := make([]ScoreRef, len(selected))
+	for i, r := range selected {
+		refs[i] = ScoreRef{
+			SongName:   r.BLLead.Song.Name,
+			Hash:       r.BLLead.Song.Hash,
+			Difficulty: r.BLLead.Difficulty.DifficultyName,
+			Mode:       r.BLLead.Difficulty.ModeName,
+			Score:      r.Score,
+			result:     r,
+		}
+		if r.Score != nil {
+			refs[i].Time = r.Score.Time()
+		}
+	}
+	return refs
+}
+
+func (s *dirSource) FetchLeaderboard(ctx context.Context, ref ScoreRef) (*utils.BLLeaderboard, error) {
+	if ref.result == nil {
+		return nil, fmt.Errorf("%s is not part of %s", ref.SongName, s.Name())
+	}
+	return ref.result.BLLead, nil
+}
+
+func (s *dirSource) FetchPlayStats(ctx context.Context, playerId string, ref ScoreRef) (*utils.BLScore, *utils.ScoreStats, error) {
+	if ref.result == nil {
+		return nil, nil, fmt.Errorf("%s is not part of %s", ref.SongName, s.Name())
+	}
+	return ref.result.Score, ref.result.Stats, nil
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"playerAnalyzer/utils"
+	"strings"
+)
+
+const ssPlayerUrl = "https://scoresaber.com/api/player/%s/basic"
+const ssPlayerSearchUrl = "https://scoresaber.com/api/players?search=%s"
+const blPlayerUrl = "https://api.beatleader.com/player/%s"
+
+// ResolvePlayer finds the ScoreSaber players matching query, which may be a ScoreSaber id,
+// a ScoreSaber or BeatLeader profile url or (part of) a player name.
+// Ids and urls resolve to exactly one player, names may match several.
+func ResolvePlayer(ctx context.Context, client *utils.Client, query string) ([]*utils.SSPlayer, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, errors.New("empty player query")
+	}
+
+	if utils.RequireNumbers(query) {
+		return fetchSSPlayer(ctx, client, query)
+	}
+
+	if u, err := url.Parse(query); err == nil && u.Host != "" {
+		return resolveProfileUrl(ctx, client, u)
+	}
+
+	if len(query) < 3 {
+		return nil, fmt.Errorf("player name %q is too short to search for", query)
+	}
+	res, err := utils.Fetch[utils.SSPlayersResponse](ctx, client, fmt.Sprintf(ssPlayerSearchUrl, url.QueryEscape(query)))
+	if utils.IsNotFound(err) {
+		return nil, fmt.Errorf("no player found for %q", query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	players := make([]*utils.SSPlayer, len(res.Players))
+	for i := range res.Players {
+		players[i] = &res.Players[i]
+	}
+
+	// an exact name match is preferred over players merely containing the query
+	var exact []*utils.SSPlayer
+	for _, p := range players {
+		if strings.EqualFold(p.Name, query) {
+			exact = append(exact, p)
+		}
+	}
+	if len(exact) == 1 {
+		return exact, nil
+	}
+	if len(players) == 0 {
+		return nil, fmt.Errorf("no player found for %q", query)
+	}
+	return players, nil
+}
+
+// resolveProfileUrl extracts the player of scoresaber.com/u/<id> and beatleader.com/u/<id or alias> urls
+func resolveProfileUrl(ctx context.Context, client *utils.Client, u *url.URL) ([]*utils.SSPlayer, error) {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 || segments[0] != "u" || segments[1] == "" {
+		return nil, fmt.Errorf("unsupported profile url %q, expected .../u/<player>", u.String())
+	}
+	id := segments[1]
+
+	host := strings.TrimPrefix(u.Host, "www.")
+	switch {
+	case host == "scoresaber.com":
+		if !utils.RequireNumbers(id) {
+			return nil, fmt.Errorf("invalid ScoreSaber id %q in %q", id, u.String())
+		}
+		return fetchSSPlayer(ctx, client, id)
+	case strings.HasPrefix(host, "beatleader."):
+		// BeatLeader accepts aliases as well and answers with the actual id, which matches the ScoreSaber one
+		blPlayer, err := utils.Fetch[utils.BLPlayer](ctx, client, fmt.Sprintf(blPlayerUrl, url.PathEscape(id)))
+		if utils.IsNotFound(err) {
+			return nil, fmt.Errorf("no BeatLeader player %q", id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return fetchSSPlayer(ctx, client, blPlayer.Id)
+	}
+	return nil, fmt.Errorf("unsupported profile url %q, expected a ScoreSaber or BeatLeader profile", u.String())
+}
+
+func fetchSSPlayer(ctx context.Context, client *utils.Client, id string) ([]*utils.SSPlayer, error) {
+	player, err := utils.Fetch[utils.SSPlayer](ctx, client, fmt.Sprintf(ssPlayerUrl, id))
+	if utils.IsNotFound(err) {
+		return nil, fmt.Errorf("no ScoreSaber player with id %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*utils.SSPlayer{player}, nil
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultConcurrency is the amount of plays fetched in parallel if nothing else is configured
+const DefaultConcurrency = 4
+
+// Sources plays can be fetched from
+const (
+	// SourceScoreSaber lists scores on ScoreSaber and looks each of them up on BeatLeader
+	SourceScoreSaber = "ss"
+	// SourceBeatLeader lists scores on BeatLeader only
+	SourceBeatLeader = "bl"
+	// SourceDirPrefix followed by a path reads plays from datasets written by fetch
+	SourceDirPrefix = "dir:"
+)
+
+// ScoreSource provides the plays of a player, wherever they come from
+type ScoreSource interface {
+	// Name describes the source in logs and datasets
+	Name() string
+	// ResolvePlayer finds the players matching an id, profile url or name
+	ResolvePlayer(ctx context.Context, query string) ([]*utils.SSPlayer, error)
+	// ListScores returns the scores of playerId selected by settings, in the order of settings.Sort.
+	// Scores set before settings.Filters.Since are left out, with recent sorting the listing stops at the first of them.
+	ListScores(ctx context.Context, playerId string, settings models.Settings) ([]ScoreRef, error)
+	// FetchLeaderboard returns the leaderboard metadata (NJS, stars, ...) of the map of ref
+	FetchLeaderboard(ctx context.Context, ref ScoreRef) (*utils.BLLeaderboard, error)
+	// FetchPlayStats returns the BeatLeader score and its stats (JD, accuracy, ...) of ref
+	FetchPlayStats(ctx context.Context, playerId string, ref ScoreRef) (*utils.BLScore, *utils.ScoreStats, error)
+}
+
+// ScoreRef identifies a single play of a player
+type ScoreRef struct {
+	SongName   string
+	Hash       string
+	Difficulty string
+	// Mode is the BeatLeader characteristic, e.g. Standard or OneSaber
+	Mode string
+	// Time is when the score was set
+	Time time.Time
+	// Skip is set for listed scores that are reported but not fetched, e.g. SkipUnranked
+	Skip string
+	// Score is set if the listing already returned the BeatLeader score
+	Score *utils.BLScore
+
+	// result is set by sources that have the whole play at hand already
+	result *utils.StatsResult
+}
+
+// NewSource creates the source with the given name, see SourceScoreSaber, SourceBeatLeader, SourceDirPrefix and SourceStore
+func NewSource(name string, client *utils.Client) (ScoreSource, error) {
+	switch {
+	case name == SourceScoreSaber:
+		return &scoreSaberSource{client: client}, nil
+	case name == SourceBeatLeader:
+		return &beatLeaderSource{client: client}, nil
+	case strings.HasPrefix(name, SourceDirPrefix):
+		dir := strings.TrimPrefix(name, SourceDirPrefix)
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("invalid source %q, %s is not a directory", name, dir)
+		}
+		return &dirSource{dir: dir}, nil
+	case name == SourceStore || strings.HasPrefix(name, SourceStore+":"):
+		path := strings.TrimPrefix(strings.TrimPrefix(name, SourceStore), ":")
+		if path == "" {
+			path = DefaultStorePath
+		}
+		store, err := OpenStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return &storeSource{store: store}, nil
+	}
+	return nil, fmt.Errorf("invalid source %q, expected ss, bl, dir:<path> or store[:<path>]", name)
+}
+
+// Collect lists the scores of playerId on src and fetches leaderboard and stats of each with up to concurrency
+// requests in parallel. Plays missing anywhere are skipped and counted in the summary; the order of the listing is kept.
+// progress may be nil.
+func Collect(ctx context.Context, src ScoreSource, playerId string, settings models.Settings, concurrency int, progress Progress) ([]*utils.StatsResult, *FetchSummary, error) {
+	refs, err := src.ListScores(ctx, playerId, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.Info(fmt.Sprintf("Listed %d scores of %s on %s", len(refs), playerId, src.Name()))
+
+	return collect(ctx, src, playerId, refs, settings.Filters, concurrency, progress)
+}
+
+// collect fetches the plays of refs accepted by filters, see Collect
+func collect(ctx context.Context, src ScoreSource, playerId string, refs []ScoreRef, filters models.Filters, concurrency int, progress Progress) ([]*utils.StatsResult, *FetchSummary, error) {
+	summary := &FetchSummary{Listed: len(refs)}
+
+	var jobs []int
+	for i, ref := range refs {
+		if ref.Skip != "" {
+			summary.skip(i, ref, ref.Skip, nil)
+			continue
+		}
+		// only filters that mean the same on every source are checked here, the rest is left to training
+		if !filters.AcceptsHash(ref.Hash) || !filters.AcceptsDifficulty(ref.Difficulty) || !filters.AcceptsCharacteristic(ref.Mode) {
+			summary.skip(i, ref, SkipFiltered, nil)
+			continue
+		}
+		jobs = append(jobs, i)
+	}
+
+	results, err := fetchConcurrently(ctx, jobs, concurrency, progress, func(ctx context.Context, i int) (*utils.StatsResult, error) {
+		return collectPlay(ctx, src, playerId, i, refs[i], summary)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	summary.Fetched = len(results)
+	summary.sortSkipped()
+	return results, summary, nil
+}
+
+// collectPlay fetches leaderboard and stats of a single play, returning nil if any of them is unavailable.
+// An error is only returned if fetching should stop altogether.
+func collectPlay(ctx context.Context, src ScoreSource, playerId string, i int, ref ScoreRef, summary *FetchSummary) (*utils.StatsResult, error) {
+	if ref.result != nil {
+		return ref.result, nil
+	}
+	slog.Debug(fmt.Sprintf("(%d) - %s", i+1, ref.SongName))
+
+	// the play is looked up first, most plays missing on BeatLeader are known after a single request
+	score, stats, err := src.FetchPlayStats(ctx, playerId, ref)
+	if err != nil {
+		return nil, skipPlay(summary, i, ref, SkipMissingScore, err)
+	}
+
+	lead, err := src.FetchLeaderboard(ctx, ref)
+	if err != nil {
+		return nil, skipPlay(summary, i, ref, SkipMissingLeaderboard, err)
+	}
+
+	return &utils.StatsResult{
+		BLLead: lead,
+		Score:  score,
+		Stats:  stats,
+	}, nil
+}
+
+// skipPlay records why a play is skipped and returns err again if it must abort the whole fetch.
+// A reason attached to err by the source takes precedence over the given one.
+func skipPlay(summary *FetchSummary, i int, ref ScoreRef, reason string, err error) error {
+	if errors.Is(err, utils.ErrFixtureMissing) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	var se *skipError
+	if errors.As(err, &se) {
+		reason, err = se.reason, se.err
+	}
+	slog.Debug(fmt.Sprintf("Skipping %s (%s): %s", ref.SongName, reason, err.Error()))
+	summary.skip(i, ref, reason, err)
+	return nil
+}
+
+// fetchConcurrently calls fetch for every job with up to concurrency workers and returns the non-nil results in job order.
+// The first error returned by fetch cancels all remaining work. progress, if not nil, is called after every finished job.
+func fetchConcurrently(ctx context.Context, jobs []int, concurrency int, progress Progress, fetch func(ctx context.Context, i int) (*utils.StatsResult, error)) ([]*utils.StatsResult, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var done atomic.Int64
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// each worker writes only to its own index, so the order of the jobs is kept
+	results := make([]*utils.StatsResult, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				res, err := fetch(ctx, jobs[j])
+				if err != nil {
+					cancel(err)
+					continue
+				}
+				results[j] = res
+				if progress != nil {
+					progress(int(done.Add(1)), len(jobs))
+				}
+			}
+		}()
+	}
+
+	for j := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+
+	var res []*utils.StatsResult
+	for _, r := range results {
+		if r != nil {
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
+}
@@ -1,80 +1,91 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"playerAnalyzer/models"
 	"playerAnalyzer/utils"
+	"time"
 )
 
 const ssScoresUrl = "https://scoresaber.com/api/player/%s/scores?limit=%d&sort=%s&page=%d&withMetadata=true"
-const blSpecScoreUrl = "https://api.beatleader.com/score/%s/%s/%s/Standard?leaderboardContext=general"
-const blLeaderboardUrl = "https://api.beatleader.com/leaderboard/%s/%s/Standard"
+const blSpecScoreUrl = "https://api.beatleader.com/score/%s/%s/%s/%s?leaderboardContext=general"
+const blLeaderboardUrl = "https://api.beatleader.com/leaderboard/%s/%s/%s"
 const statsUrl = "https://cdn.scorestats.beatleader.com/%d.json"
 
 // scoresaber scores > songHash + difficulty + gameMode > bl /leaderboard/hash/diff/mode > score > id > stats
 
-func FetchStats(playerId string, settings models.Settings) ([]*utils.StatsResult, error) {
-	var res []*utils.StatsResult
+// scoreSaberSource lists scores on ScoreSaber and looks each play up on BeatLeader
+type scoreSaberSource struct {
+	client *utils.Client
+}
+
+func (s *scoreSaberSource) Name() string {
+	return "ScoreSaber"
+}
+
+func (s *scoreSaberSource) ResolvePlayer(ctx context.Context, query string) ([]*utils.SSPlayer, error) {
+	return ResolvePlayer(ctx, s.client, query)
+}
 
-	ssScores, err := fetchAllScores(playerId, settings.Count, settings.Sort)
+func (s *scoreSaberSource) ListScores(ctx context.Context, playerId string, settings models.Settings) ([]ScoreRef, error) {
+	ssScores, err := fetchAllScores(ctx, s.client, playerId, settings.Count, settings.Sort, since(settings))
 	if err != nil {
 		return nil, err
 	}
-	slog.Info(fmt.Sprintf("Fetched %d scores of %s", len(ssScores.PlayerScores), playerId))
 
-	for i, score := range ssScores.PlayerScores {
-		if settings.Ranked && !score.Leaderboard.Ranked {
+	var refs []ScoreRef
+	for _, score := range ssScores.PlayerScores {
+		if settings.Filters.Since != nil && score.Score.TimeSet.Before(*settings.Filters.Since) {
 			continue
 		}
-
-		slog.Info(fmt.Sprintf("(%d) - %s", i+1, score.Leaderboard.SongName))
-		// Fetching concrete BL play by criteria
-		blScore, err := utils.FetchToStruct[utils.BLScore](fmt.Sprintf(blSpecScoreUrl, playerId, score.Leaderboard.SongHash, formatSSDiff(score.Leaderboard.Difficulty.Difficulty)))
-		if err != nil {
-			slog.Info("BL Score: " + err.Error())
-			continue
+		ref := ScoreRef{
+			SongName:   score.Leaderboard.SongName,
+			Hash:       score.Leaderboard.SongHash,
+			Difficulty: formatSSDiff(score.Leaderboard.Difficulty.Difficulty),
+			Mode:       utils.CharacteristicOf(score.Leaderboard.Difficulty.GameMode),
+			Time:       score.Score.TimeSet,
 		}
-
-		blLead, err := utils.FetchToStruct[utils.BLLeaderboard](fmt.Sprintf(blLeaderboardUrl, score.Leaderboard.SongHash, formatSSDiff(score.Leaderboard.Difficulty.Difficulty)))
-		if err != nil {
-			slog.Info("BL Leaderboard: " + err.Error())
-			continue
+		if settings.Ranked && !score.Leaderboard.Ranked {
+			ref.Skip = SkipUnranked
 		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
 
-		// Fetching corresponding stats of the play
-		blStats, err := utils.FetchToStruct[utils.ScoreStats](fmt.Sprintf(statsUrl, blScore.Id))
-		if err != nil {
-			slog.Info("BL Stats: " + err.Error())
-			continue
-		}
+func (s *scoreSaberSource) FetchLeaderboard(ctx context.Context, ref ScoreRef) (*utils.BLLeaderboard, error) {
+	return utils.Fetch[utils.BLLeaderboard](ctx, s.client, fmt.Sprintf(blLeaderboardUrl, ref.Hash, ref.Difficulty, ref.Mode))
+}
 
-		res = append(res, &utils.StatsResult{
-			BLLead: blLead,
-			Stats:  blStats,
-		})
+func (s *scoreSaberSource) FetchPlayStats(ctx context.Context, playerId string, ref ScoreRef) (*utils.BLScore, *utils.ScoreStats, error) {
+	// Fetching concrete BL play by criteria
+	blScore, err := utils.Fetch[utils.BLScore](ctx, s.client, fmt.Sprintf(blSpecScoreUrl, playerId, ref.Hash, ref.Difficulty, ref.Mode))
+	if err != nil {
+		return nil, nil, &skipError{reason: SkipMissingScore, err: err}
 	}
 
-	return res, nil
+	// Fetching corresponding stats of the play
+	blStats, err := utils.Fetch[utils.ScoreStats](ctx, s.client, fmt.Sprintf(statsUrl, blScore.Id))
+	if err != nil {
+		return nil, nil, &skipError{reason: SkipMissingStats, err: err}
+	}
+	return blScore, blStats, nil
 }
 
-func fetchAllScores(playerId string, count int, sortOrder string) (*utils.SSScoreResponse, error) {
+// fetchAllScores fetches up to count scores; with a non-zero since, recent scores are only fetched until one older than since shows up
+func fetchAllScores(ctx context.Context, client *utils.Client, playerId string, count int, sortOrder string, since time.Time) (*utils.SSScoreResponse, error) {
 	const maxScoresPerPage = 100
 
-	allScores := &utils.SSScoreResponse{
-		PlayerScores: make([]utils.SSScore, 0, count),
-	}
+	allScores := &utils.SSScoreResponse{}
 
 	page := 1
 	remaining := count
 
+	// pages are addressed by number, so every page must have the same size for them not to overlap
 	for remaining > 0 {
-		limit := maxScoresPerPage
-		if remaining < maxScoresPerPage {
-			limit = remaining
-		}
-
-		pageScores, err := utils.FetchToStruct[utils.SSScoreResponse](fmt.Sprintf(ssScoresUrl, playerId, limit, sortOrder, page))
+		pageScores, err := utils.Fetch[utils.SSScoreResponse](ctx, client, fmt.Sprintf(ssScoresUrl, playerId, maxScoresPerPage, sortOrder, page))
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch page %d: %w", page, err)
 		}
@@ -90,7 +101,10 @@ func fetchAllScores(playerId string, count int, sortOrder string) (*utils.SSScor
 		remaining -= len(pageScores.PlayerScores)
 		page++
 
-		if len(pageScores.PlayerScores) < limit {
+		if len(pageScores.PlayerScores) < maxScoresPerPage {
+			break
+		}
+		if sortOrder == "recent" && pageScores.PlayerScores[len(pageScores.PlayerScores)-1].Score.TimeSet.Before(since) {
 			break
 		}
 
@@ -119,3 +133,11 @@ func formatSSDiff(difficulty int) string {
 	}
 	return ""
 }
+
+// since returns the start of the date window of settings, or the zero time if there is none
+func since(settings models.Settings) time.Time {
+	if settings.Filters.Since == nil {
+		return time.Time{}
+	}
+	return *settings.Filters.Since
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoreVersion is increased whenever the layout of the store file changes incompatibly
+const StoreVersion = 1
+
+const DefaultStorePath = "_cache/store.json.gz"
+
+// SourceStore reads plays from the local store, SourceStore + ":" + path from a store at another location
+const SourceStore = "store"
+
+// Store is a local database of synced plays, kept in a single compressed file and loaded into memory as a whole
+type Store struct {
+	path string
+	mu   sync.RWMutex
+	data storeData
+}
+
+type storeData struct {
+	Version      int                             `json:"version"`
+	Players      map[string]*utils.SSPlayer      `json:"players"`
+	Leaderboards map[string]*utils.BLLeaderboard `json:"leaderboards"`
+	// Scores holds the best known score of a player per leaderboard, keyed by scoreKey
+	Scores map[string]*StoredScore `json:"scores"`
+	// Stats are keyed by BeatLeader score id, they never change once uploaded
+	Stats map[int]*utils.ScoreStats `json:"stats"`
+	Syncs map[string]SyncState      `json:"syncs"`
+}
+
+// StoredScore links a score to its player and leaderboard
+type StoredScore struct {
+	PlayerId      string         `json:"playerId"`
+	LeaderboardId string         `json:"leaderboardId"`
+	Ranked        bool           `json:"ranked"`
+	Score         *utils.BLScore `json:"score"`
+}
+
+// SyncState remembers how far the scores of a player are synced
+type SyncState struct {
+	Source string `json:"source"`
+	// LastTimeSet is the time of the newest score listed by the last sync
+	LastTimeSet time.Time `json:"lastTimeSet"`
+	SyncedAt    time.Time `json:"syncedAt"`
+}
+
+// OpenStore loads the store at path, a missing file gives an empty store that is created on Save
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: storeData{
+			Version:      StoreVersion,
+			Players:      make(map[string]*utils.SSPlayer),
+			Leaderboards: make(map[string]*utils.BLLeaderboard),
+			Scores:       make(map[string]*StoredScore),
+			Stats:        make(map[int]*utils.ScoreStats),
+			Syncs:        make(map[string]SyncState),
+		},
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store %s: %w", path, err)
+	}
+	if err = json.NewDecoder(zr).Decode(&s.data); err != nil {
+		return nil, fmt.Errorf("failed to read store %s: %w", path, err)
+	}
+	if s.data.Version != StoreVersion {
+		return nil, fmt.Errorf("store %s has version %d, expected %d; remove it and sync again", path, s.data.Version, StoreVersion)
+	}
+	return s, nil
+}
+
+// Save writes the store back to its file, replacing it atomically
+func (s *Store) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(&s.data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated store behind
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+func scoreKey(playerId string, leaderboardId string) string {
+	return playerId + "/" + leaderboardId
+}
+
+// AddPlays stores player and its plays and returns how many of them were new or improved scores
+func (s *Store) AddPlays(player *utils.SSPlayer, results []*utils.StatsResult, ranked bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Players[player.Id] = player
+	added := 0
+	for _, r := range results {
+		if r.BLLead == nil || r.Score == nil || r.Stats == nil {
+			continue
+		}
+
+		key := scoreKey(player.Id, r.BLLead.Id)
+		if old, ok := s.data.Scores[key]; ok {
+			if old.Score.Id == r.Score.Id {
+				continue
+			}
+			// an improved score replaces the old one, its stats are of no use anymore
+			delete(s.data.Stats, old.Score.Id)
+		}
+
+		s.data.Leaderboards[r.BLLead.Id] = r.BLLead
+		s.data.Stats[r.Score.Id] = r.Stats
+		s.data.Scores[key] = &StoredScore{
+			PlayerId:      player.Id,
+			LeaderboardId: r.BLLead.Id,
+			Ranked:        ranked || r.BLLead.Difficulty.Status == blStatusRanked || r.Score.Pp > 0,
+			Score:         r.Score,
+		}
+		added++
+	}
+	return added
+}
+
+// Plays returns the stored plays of playerId, newest first; with ranked set only ranked ones
+func (s *Store) Plays(playerId string, ranked bool) []*utils.StatsResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var res []*utils.StatsResult
+	for _, stored := range s.data.Scores {
+		if stored.PlayerId != playerId || (ranked && !stored.Ranked) {
+			continue
+		}
+		lead, stats := s.data.Leaderboards[stored.LeaderboardId], s.data.Stats[stored.Score.Id]
+		if lead == nil || stats == nil {
+			continue
+		}
+		res = append(res, &utils.StatsResult{BLLead: lead, Score: stored.Score, Stats: stats})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Score.Time().After(res[j].Score.Time())
+	})
+	return res
+}
+
+// Player returns the stored player with the given id, or nil
+func (s *Store) Player(id string) *utils.SSPlayer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.Players[id]
+}
+
+// Players returns all stored players sorted by name
+func (s *Store) Players() []*utils.SSPlayer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*utils.SSPlayer, 0, len(s.data.Players))
+	for _, p := range s.data.Players {
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return strings.ToLower(res[i].Name) < strings.ToLower(res[j].Name)
+	})
+	return res
+}
+
+// LastSync returns the sync state of playerId, ok is false if it was never synced
+func (s *Store) LastSync(playerId string) (state SyncState, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok = s.data.Syncs[playerId]
+	return state, ok
+}
+
+func (s *Store) setSynced(playerId string, state SyncState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Syncs[playerId] = state
+}
+
+// SyncResult describes what a sync changed
+type SyncResult struct {
+	Summary *FetchSummary
+	Added   int
+	Total   int
+}
+
+// Sync pulls the scores of player set after the last sync from src into the store. The first sync takes the newest
+// settings.Count scores, later ones every score set since, however many there are.
+// Scores are listed by recency, so listing stops at the first score that is already known.
+func Sync(ctx context.Context, store *Store, src ScoreSource, player *utils.SSPlayer, settings models.Settings, concurrency int, progress Progress) (SyncResult, error) {
+	settings.Sort = "recent"
+	last, synced := store.LastSync(player.Id)
+	if synced {
+		since := last.LastTimeSet.Add(time.Second)
+		settings.Filters.Since = &since
+		// capping the listing would skip the older scores for good, the next sync starts after the newest one
+		settings.Count = math.MaxInt
+		slog.Info(fmt.Sprintf("Syncing scores of %s set after %s", player.Name, last.LastTimeSet.Format(time.RFC3339)))
+	}
+
+	refs, err := src.ListScores(ctx, player.Id, settings)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	slog.Info(fmt.Sprintf("Listed %d new scores of %s on %s", len(refs), player.Id, src.Name()))
+
+	results, summary, err := collect(ctx, src, player.Id, refs, models.Filters{}, concurrency, progress)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	// the sync only advances up to the oldest play that failed for a temporary reason, so the next one retries it
+	var retryFrom time.Time
+	failed := 0
+	for _, p := range summary.Skipped {
+		if !p.Retry {
+			continue
+		}
+		failed++
+		if t := refs[p.Index-1].Time; retryFrom.IsZero() || t.Before(retryFrom) {
+			retryFrom = t
+		}
+	}
+	if failed > 0 {
+		slog.Warn(fmt.Sprintf("%d plays failed to fetch, the next sync retries them", failed))
+	}
+
+	state := SyncState{Source: src.Name(), LastTimeSet: last.LastTimeSet, SyncedAt: time.Now().UTC()}
+	for _, ref := range refs {
+		if !retryFrom.IsZero() && !ref.Time.Before(retryFrom) {
+			continue
+		}
+		if ref.Time.After(state.LastTimeSet) {
+			state.LastTimeSet = ref.Time
+		}
+	}
+
+	// plays missing on BeatLeader are not retried by later syncs, they would be listed again and again otherwise
+	added := store.AddPlays(player, results, settings.Ranked)
+	store.setSynced(player.Id, state)
+	if err = store.Save(); err != nil {
+		return SyncResult{}, err
+	}
+
+	return SyncResult{
+		Summary: summary,
+		Added:   added,
+		Total:   len(store.Plays(player.Id, false)),
+	}, nil
+}
+
+// storeSource answers everything from a Store, without touching the network
+type storeSource struct {
+	store *Store
+}
+
+func (s *storeSource) Name() string {
+	return "store " + s.store.Path()
+}
+
+func (s *storeSource) ResolvePlayer(ctx context.Context, query string) ([]*utils.SSPlayer, error) {
+	query = strings.TrimSpace(query)
+	if p := s.store.Player(query); p != nil {
+		return []*utils.SSPlayer{p}, nil
+	}
+
+	var players []*utils.SSPlayer
+	for _, p := range s.store.Players() {
+		if strings.EqualFold(p.Name, query) {
+			return []*utils.SSPlayer{p}, nil
+		}
+		if strings.Contains(strings.ToLower(p.Name), strings.ToLower(query)) {
+			players = append(players, p)
+		}
+	}
+	if len(players) == 0 {
+		return nil, fmt.Errorf("player %q is not part of %s, run sync first", query, s.Name())
+	}
+	return players, nil
+}
+
+func (s *storeSource) ListScores(ctx context.Context, playerId string, settings models.Settings) ([]ScoreRef, error) {
+	if _, ok := s.store.LastSync(playerId); !ok {
+		return nil, fmt.Errorf("player %s was never synced into %s, run sync first", playerId, s.store.Path())
+	}
+	return selectPlays(s.store.Plays(playerId, settings.Ranked), settings), nil
+}
+
+func (s *storeSource) FetchLeaderboard(ctx context.Context, ref ScoreRef) (*utils.BLLeaderboard, error) {
+	if ref.result == nil {
+		return nil, fmt.Errorf("%s is not part of %s", ref.SongName, s.Name())
+	}
+	return ref.result.BLLead, nil
+}
+
+func (s *storeSource) FetchPlayStats(ctx context.Context, playerId string, ref ScoreRef) (*utils.BLScore, *utils.ScoreStats, error) {
+	if ref.result == nil {
+		return nil, nil, fmt.Errorf("%s is not part of %s", ref.SongName, s.Name())
+	}
+	return ref.result.Score, ref.result.Stats, nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"playerAnalyzer/models"
+	"playerAnalyzer/utils"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeSource lists scores with the ids 1 to newest, set an hour apart, each on its own map
+type fakeSource struct {
+	newest int
+	// errs are returned when fetching the play of a score id
+	errs map[int]error
+	// counts are the settings.Count of every listing
+	counts []int
+}
+
+func scoreTime(id int) time.Time {
+	return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(id) * time.Hour)
+}
+
+func (s *fakeSource) Name() string {
+	return "fake"
+}
+
+func (s *fakeSource) ResolvePlayer(ctx context.Context, query string) ([]*utils.SSPlayer, error) {
+	return []*utils.SSPlayer{{Id: query}}, nil
+}
+
+func (s *fakeSource) ListScores(ctx context.Context, playerId string, settings models.Settings) ([]ScoreRef, error) {
+	s.counts = append(s.counts, settings.Count)
+
+	var refs []ScoreRef
+	for id := s.newest; id >= 1 && len(refs) < settings.Count; id-- {
+		if settings.Filters.Since != nil && scoreTime(id).Before(*settings.Filters.Since) {
+			break
+		}
+		refs = append(refs, ScoreRef{
+			SongName:   "song " + strconv.Itoa(id),
+			Hash:       strconv.Itoa(id),
+			Difficulty: "ExpertPlus",
+			Mode:       "Standard",
+			Time:       scoreTime(id),
+		})
+	}
+	return refs, nil
+}
+
+func (s *fakeSource) FetchLeaderboard(ctx context.Context, ref ScoreRef) (*utils.BLLeaderboard, error) {
+	return &utils.BLLeaderboard{Id: "lb" + ref.Hash}, nil
+}
+
+func (s *fakeSource) FetchPlayStats(ctx context.Context, playerId string, ref ScoreRef) (*utils.BLScore, *utils.ScoreStats, error) {
+	id, _ := strconv.Atoi(ref.Hash)
+	if err := s.errs[id]; err != nil {
+		return nil, nil, err
+	}
+	score := &utils.BLScore{Id: id, Timeset: strconv.FormatInt(scoreTime(id).Unix(), 10)}
+	return score, &utils.ScoreStats{}, nil
+}
+
+func TestSync(t *testing.T) {
+	serverError := &utils.HTTPError{URL: "https://api.beatleader.com/score", StatusCode: 503}
+	notFound := &utils.HTTPError{URL: "https://api.beatleader.com/score", StatusCode: 404}
+
+	// the steps run one after another against the same store
+	steps := []struct {
+		name   string
+		newest int
+		errs   map[int]error
+		// count is the listing cap the source must see, 0 for none
+		count     int
+		wantAdded int
+		wantTotal int
+		wantRetry int
+		// wantLast is the id of the score the sync advanced to
+		wantLast int
+	}{
+		{name: "first sync takes the newest count scores", newest: 5, count: 3, wantAdded: 3, wantTotal: 3, wantLast: 5},
+		{
+			name:   "later syncs take every new score and stop before a failed one",
+			newest: 10, errs: map[int]error{8: serverError},
+			wantAdded: 4, wantTotal: 7, wantRetry: 1, wantLast: 7,
+		},
+		{name: "the failed score is retried", newest: 10, wantAdded: 1, wantTotal: 8, wantLast: 10},
+		{
+			name:   "scores missing on BeatLeader are skipped for good",
+			newest: 12, errs: map[int]error{11: notFound},
+			wantAdded: 1, wantTotal: 9, wantLast: 12,
+		},
+		{name: "nothing new", newest: 12, wantAdded: 0, wantTotal: 9, wantLast: 12},
+	}
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "store.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	player := &utils.SSPlayer{Id: "1", Name: "Tester"}
+	settings := models.Settings{Count: 3, Sort: "top"}
+
+	for _, step := range steps {
+		src := &fakeSource{newest: step.newest, errs: step.errs}
+		res, err := Sync(context.Background(), store, src, player, settings, 2, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if step.count != 0 && src.counts[0] != step.count {
+			t.Errorf("%s: listed %d scores, want %d", step.name, src.counts[0], step.count)
+		}
+		if step.count == 0 && src.counts[0] < step.newest {
+			t.Errorf("%s: listed at most %d scores, want no cap", step.name, src.counts[0])
+		}
+		if res.Added != step.wantAdded || res.Total != step.wantTotal {
+			t.Errorf("%s: added %d of %d plays, want %d of %d", step.name, res.Added, res.Total, step.wantAdded, step.wantTotal)
+		}
+		retry := 0
+		for _, p := range res.Summary.Skipped {
+			if p.Retry {
+				retry++
+			}
+		}
+		if retry != step.wantRetry {
+			t.Errorf("%s: %d plays to retry, want %d: %+v", step.name, retry, step.wantRetry, res.Summary.Skipped)
+		}
+		last, _ := store.LastSync(player.Id)
+		if want := scoreTime(step.wantLast); !last.LastTimeSet.Equal(want) {
+			t.Errorf("%s: synced up to %s, want %s (score %d)", step.name, last.LastTimeSet, want, step.wantLast)
+		}
+	}
+
+	// the store on disk holds the same
+	reopened, err := OpenStore(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, p := range reopened.Plays(player.Id, false) {
+		ids = append(ids, p.Score.Id)
+	}
+	if got, want := fmt.Sprint(ids), "[12 10 9 8 7 6 5 4 3]"; got != want {
+		t.Errorf("stored plays %s, want %s", got, want)
+	}
+}
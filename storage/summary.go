@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"playerAnalyzer/utils"
+	"sort"
+	"sync"
+)
+
+// Reasons listed scores are skipped for
+const (
+	SkipUnranked           = "unranked"
+	SkipFiltered           = "filtered"
+	SkipMissingScore       = "missing on BeatLeader"
+	SkipMissingLeaderboard = "missing leaderboard"
+	SkipMissingStats       = "missing stats"
+)
+
+// SkipReasons lists all reasons in the order they are reported
+var SkipReasons = []string{SkipUnranked, SkipFiltered, SkipMissingScore, SkipMissingLeaderboard, SkipMissingStats}
+
+// Progress is called whenever another of total plays is done
+type Progress func(done, total int)
+
+// SkippedPlay is a listed score that did not make it into the results
+type SkippedPlay struct {
+	// Index is the position in the listing, starting at 1
+	Index      int    `json:"index"`
+	SongName   string `json:"songName"`
+	Hash       string `json:"hash"`
+	Difficulty string `json:"difficulty"`
+	Mode       string `json:"mode"`
+	Reason     string `json:"reason"`
+	Error      string `json:"error,omitempty"`
+	// Retry is set if the play failed for a reason that may go away, like a server error or timeout
+	Retry bool `json:"retry,omitempty"`
+}
+
+// FetchSummary counts what happened to the listed scores of a fetch
+type FetchSummary struct {
+	Listed  int
+	Fetched int
+	Skipped []SkippedPlay
+
+	mu sync.Mutex
+}
+
+func (s *FetchSummary) skip(i int, ref ScoreRef, reason string, err error) {
+	play := SkippedPlay{
+		Index:      i + 1,
+		SongName:   ref.SongName,
+		Hash:       ref.Hash,
+		Difficulty: ref.Difficulty,
+		Mode:       ref.Mode,
+		Reason:     reason,
+	}
+	if err != nil {
+		play.Error = err.Error()
+		play.Retry = !utils.IsNotFound(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Skipped = append(s.Skipped, play)
+}
+
+// Count returns how many plays were skipped for reason
+func (s *FetchSummary) Count(reason string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, p := range s.Skipped {
+		if p.Reason == reason {
+			n++
+		}
+	}
+	return n
+}
+
+// sortSkipped orders the skipped plays like the listing, workers add them in random order
+func (s *FetchSummary) sortSkipped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.Skipped, func(i, j int) bool {
+		return s.Skipped[i].Index < s.Skipped[j].Index
+	})
+}
+
+// skipError tells which part of a play could not be fetched, so it is reported with the right reason
+type skipError struct {
+	reason string
+	err    error
+}
+
+func (e *skipError) Error() string {
+	return e.err.Error()
+}
+
+func (e *skipError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Immutable marks responses that never change and thus never expire
+const Immutable time.Duration = -1
+
+// CacheRule assigns a time to live to all urls starting with Prefix
+type CacheRule struct {
+	Prefix string
+	TTL    time.Duration
+}
+
+var DefaultCacheRules = []CacheRule{
+	{Prefix: "https://cdn.scorestats.beatleader.com/", TTL: Immutable},
+	{Prefix: "https://api.beatleader.com/leaderboard/", TTL: 24 * time.Hour},
+	{Prefix: "https://api.beatleader.com/score/", TTL: time.Hour},
+	{Prefix: "https://scoresaber.com/api/player/", TTL: 10 * time.Minute},
+}
+
+// Cache is an on-disk response cache, storing each response in a file named after the hash of its url
+type Cache struct {
+	Dir      string
+	Rules    []CacheRule
+	Disabled bool
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	Url       string          `json:"url"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// CacheEntryInfo describes a stored response without its body
+type CacheEntryInfo struct {
+	Path      string
+	Url       string
+	FetchedAt time.Time
+	Size      int64
+	Expired   bool
+}
+
+var DefaultCache = &Cache{
+	Dir:   "_cache/http",
+	Rules: DefaultCacheRules,
+}
+
+// TTL returns the time to live of responses of u, ok is false if u should not be cached
+func (c *Cache) TTL(u string) (ttl time.Duration, ok bool) {
+	for _, rule := range c.Rules {
+		if strings.HasPrefix(u, rule.Prefix) {
+			return rule.TTL, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Cache) Get(u string) ([]byte, bool) {
+	if c.Disabled {
+		return nil, false
+	}
+	ttl, ok := c.TTL(u)
+	if !ok {
+		return nil, false
+	}
+
+	entry, err := c.read(c.path(u))
+	if err != nil || entry.Url != u || isExpired(entry.FetchedAt, ttl) {
+		c.misses.Add(1)
+		slog.Debug("Cache miss: " + u)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	slog.Debug("Cache hit: " + u)
+	return entry.Body, true
+}
+
+// Put stores body as response of u, responses of urls without a matching rule are ignored
+func (c *Cache) Put(u string, body []byte) error {
+	if c.Disabled {
+		return nil
+	}
+	if _, ok := c.TTL(u); !ok {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(cacheEntry{
+		Url:       u,
+		FetchedAt: time.Now().UTC(),
+		Body:      body,
+	})
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file of its own first, so concurrent readers never see partial entries and concurrent
+	// writers of the same url don't write into each others file
+	tmp, err := os.CreateTemp(c.Dir, "*.json.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(bytes)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(u))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Stats returns the amount of cache hits and misses since the program started
+func (c *Cache) Stats() (hits int64, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// Entries lists all stored responses
+func (c *Cache) Entries() ([]CacheEntryInfo, error) {
+	files, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]CacheEntryInfo, 0, len(files))
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		info := CacheEntryInfo{
+			Path: file,
+			Size: fi.Size(),
+		}
+
+		entry, err := c.read(file)
+		if err != nil {
+			// unreadable entries are treated as expired so prune gets rid of them
+			info.Expired = true
+			entries = append(entries, info)
+			continue
+		}
+		info.Url = entry.Url
+		info.FetchedAt = entry.FetchedAt
+
+		ttl, ok := c.TTL(entry.Url)
+		info.Expired = !ok || isExpired(entry.FetchedAt, ttl)
+
+		entries = append(entries, info)
+	}
+
+	return entries, nil
+}
+
+// Prune removes all expired entries and returns how many were removed
+func (c *Cache) Prune() (int, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.Expired {
+			continue
+		}
+		if err = os.Remove(entry.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Clear removes all entries
+func (c *Cache) Clear() error {
+	return os.RemoveAll(c.Dir)
+}
+
+func (c *Cache) path(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) read(path string) (*cacheEntry, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry cacheEntry
+	if err = json.Unmarshal(bytes, &entry); err != nil {
+		return nil, fmt.Errorf("corrupt cache entry %s: %w", path, err)
+	}
+	return &entry, nil
+}
+
+func isExpired(fetchedAt time.Time, ttl time.Duration) bool {
+	if ttl == Immutable {
+		return false
+	}
+	return time.Since(fetchedAt) > ttl
+}
+
+// HostOf returns the host part of u or u itself if it cannot be parsed
+func HostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return u
+	}
+	return parsed.Host
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheConcurrentPut(t *testing.T) {
+	c := &Cache{Dir: t.TempDir(), Rules: []CacheRule{{Prefix: "https://api.test", TTL: time.Hour}}}
+	url := "https://api.test/scores"
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.Put(url, []byte(fmt.Sprintf(`{"value": %d}`, i)))
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Put() %d: %v", i, err)
+		}
+	}
+
+	body, ok := c.Get(url)
+	var res testResponse
+	if !ok || json.Unmarshal(body, &res) != nil {
+		t.Errorf("Get() = %q, %v, want one of the written bodies", body, ok)
+	}
+	files, err := os.ReadDir(c.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("cache holds %d files, want only the entry", len(files))
+	}
+}
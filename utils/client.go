@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 4
+	defaultBaseDelay  = 500 * time.Millisecond
+	maxBackoff        = 30 * time.Second
+	snippetLength     = 200
+)
+
+// HTTPError is returned for responses with a status other than 200
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Snippet    string
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Snippet != "" {
+		msg += ": " + e.Snippet
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a HTTPError with status 404
+func IsNotFound(err error) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
+}
+
+// Client fetches API responses, going through the response cache and per-host rate limits
+// and retrying server errors and rate limited requests with exponential backoff
+type Client struct {
+	HTTP       *http.Client
+	Cache      *Cache
+	Limiter    *HostLimiter
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+// NewClient creates a client sending requests through transport, which defaults to http.DefaultTransport if nil
+func NewClient(transport http.RoundTripper) *Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Client{
+		HTTP: &http.Client{
+			Transport: transport,
+			Timeout:   defaultTimeout,
+		},
+		Cache:      DefaultCache,
+		Limiter:    DefaultLimiter,
+		MaxRetries: defaultMaxRetries,
+		BaseDelay:  defaultBaseDelay,
+	}
+}
+
+var DefaultClient = NewClient(nil)
+
+// Fetch gets url with c and unmarshals the response into a new T
+func Fetch[T any](ctx context.Context, c *Client, url string) (*T, error) {
+	bytes, cached, err := c.get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	var str T
+
+	err = json.Unmarshal(bytes, &str)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response of %s: %w", url, err)
+	}
+
+	if !cached && c.Cache != nil {
+		if err = c.Cache.Put(url, bytes); err != nil {
+			slog.Warn("Failed to cache response: " + err.Error())
+		}
+	}
+
+	return &str, nil
+}
+
+// get returns the body of url, either from the cache or from the network
+func (c *Client) get(ctx context.Context, url string) (body []byte, cached bool, err error) {
+	if c.Cache != nil {
+		if body, ok := c.Cache.Get(url); ok {
+			return body, true, nil
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := c.do(ctx, url)
+		if err == nil {
+			return body, false, nil
+		}
+		if retryAfter < 0 || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return nil, false, err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		slog.Info(fmt.Sprintf("Retrying %s in %s (%s)", url, wait.Round(time.Millisecond), err))
+
+		if retryAfter > 0 && c.Limiter != nil {
+			// the whole host told us to slow down, not just this request
+			c.Limiter.Pause(url, wait)
+		}
+		if err = sleep(ctx, wait); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// do sends a single request. retryAfter is negative if the error is not worth retrying,
+// otherwise it is the minimum delay the server asked for
+func (c *Client) do(ctx context.Context, url string) (body []byte, retryAfter time.Duration, err error) {
+	if c.Limiter != nil {
+		if err = c.Limiter.Wait(ctx, url); err != nil {
+			return nil, -1, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if errors.Is(err, ErrFixtureMissing) {
+		return nil, -1, err
+	}
+	if err != nil {
+		// network errors and timeouts are retried
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return body, 0, nil
+	}
+
+	httpErr := &HTTPError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Snippet:    snippet(body),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil, RetryAfter(resp, 5*time.Second), httpErr
+	case resp.StatusCode >= 500:
+		return nil, RetryAfter(resp, 0), httpErr
+	}
+	return nil, -1, httpErr
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.BaseDelay << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	// up to 25% jitter, so parallel workers don't retry in lockstep
+	return d + time.Duration(rand.Int63n(int64(d)/4+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func snippet(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) > snippetLength {
+		s = s[:snippetLength] + "..."
+	}
+	return s
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testResponse struct {
+	Value int `json:"value"`
+}
+
+// testServer answers the n-th request (counting from 0) with the n-th handler, the last one answers all further ones
+func testServer(t *testing.T, handlers ...http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1)) - 1
+		handlers[min(n, len(handlers)-1)](w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+// testClient creates a client without rate limits and with a short backoff, caching responses of srv in a temporary directory
+func testClient(t *testing.T, srv *httptest.Server) *Client {
+	c := NewClient(srv.Client().Transport)
+	c.Cache = &Cache{Dir: t.TempDir(), Rules: []CacheRule{{Prefix: srv.URL, TTL: time.Hour}}}
+	c.Limiter = nil
+	c.BaseDelay = time.Millisecond
+	return c
+}
+
+func status(code int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		_, _ = fmt.Fprint(w, body)
+	}
+}
+
+func retryAfter(value string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", value)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	ok := status(http.StatusOK, `{"value": 42}`)
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		retries  int
+		// requests is the amount of requests sent, wantErr is the status code of the returned error or 0 for success
+		requests int32
+		wantErr  int
+	}{
+		{"success", []http.HandlerFunc{ok}, 4, 1, 0},
+		{"server error retried", []http.HandlerFunc{status(500, ""), status(503, ""), ok}, 4, 3, 0},
+		{"rate limit retried", []http.HandlerFunc{retryAfter("0"), ok}, 4, 2, 0},
+		{"retries run out", []http.HandlerFunc{status(502, "bad gateway")}, 2, 3, 502},
+		{"not found not retried", []http.HandlerFunc{status(404, "not found"), ok}, 4, 1, 404},
+		{"bad request not retried", []http.HandlerFunc{status(400, ""), ok}, 4, 1, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := testServer(t, tt.handlers...)
+			c := testClient(t, srv)
+			c.MaxRetries = tt.retries
+
+			res, err := Fetch[testResponse](context.Background(), c, srv.URL+"/scores")
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("sent %d requests, want %d", got, tt.requests)
+			}
+			if tt.wantErr == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Value != 42 {
+					t.Errorf("Fetch() = %+v, want value 42", res)
+				}
+				return
+			}
+
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != tt.wantErr {
+				t.Fatalf("Fetch() error = %v, want status %d", err, tt.wantErr)
+			}
+			if IsNotFound(err) != (tt.wantErr == 404) {
+				t.Errorf("IsNotFound(%v) = %v", err, IsNotFound(err))
+			}
+		})
+	}
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	srv, requests := testServer(t, retryAfter("1"), status(http.StatusOK, `{"value": 1}`))
+	c := testClient(t, srv)
+
+	start := time.Now()
+	if _, err := Fetch[testResponse](context.Background(), c, srv.URL+"/scores"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the second asked for by Retry-After", elapsed)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("sent %d requests, want 2", got)
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	long := strings.Repeat("x ", snippetLength)
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", ""},
+		{"whitespace collapsed", "  {\n\t\"error\":   \"unknown player\"\n}  ", `{ "error": "unknown player" }`},
+		{"cut", long, strings.TrimSpace(long)[:snippetLength] + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := testServer(t, status(http.StatusNotFound, tt.body))
+			c := testClient(t, srv)
+			url := srv.URL + "/player/1"
+
+			_, err := Fetch[testResponse](context.Background(), c, url)
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("Fetch() error = %v, want a HTTPError", err)
+			}
+			if httpErr.URL != url || httpErr.StatusCode != http.StatusNotFound || httpErr.Snippet != tt.want {
+				t.Errorf("Fetch() error = %+v, want url %s, status 404 and snippet %q", httpErr, url, tt.want)
+			}
+			if !strings.Contains(err.Error(), url) || !strings.Contains(err.Error(), "404 Not Found") {
+				t.Errorf("Error() = %q, want the url and status", err.Error())
+			}
+		})
+	}
+
+	if IsNotFound(errors.New("404")) || IsNotFound(nil) {
+		t.Error("IsNotFound() is true for an error that is no HTTPError")
+	}
+	if !IsNotFound(fmt.Errorf("wrapped: %w", &HTTPError{StatusCode: 404})) {
+		t.Error("IsNotFound() is false for a wrapped HTTPError")
+	}
+}
+
+func TestClientCancelDuringBackoff(t *testing.T) {
+	srv, requests := testServer(t, status(http.StatusServiceUnavailable, ""))
+	c := testClient(t, srv)
+	c.BaseDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Fetch[testResponse](ctx, c, srv.URL+"/scores")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Fetch() returned after %s, want it to stop waiting once cancelled", elapsed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	srv, requests := testServer(t,
+		status(http.StatusOK, `not json`),
+		status(http.StatusOK, `{"value": 7}`),
+	)
+	c := testClient(t, srv)
+	url := srv.URL + "/scores"
+
+	if _, err := Fetch[testResponse](context.Background(), c, url); err == nil {
+		t.Fatal("Fetch() parsed an invalid response")
+	}
+	if _, ok := c.Cache.Get(url); ok {
+		t.Fatal("the invalid response was cached")
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := Fetch[testResponse](context.Background(), c, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Value != 7 {
+			t.Errorf("Fetch() = %+v, want value 7", res)
+		}
+	}
+	// the valid response is cached, so only the invalid one is fetched again
+	if got := requests.Load(); got != 2 {
+		t.Errorf("sent %d requests, want 2", got)
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrFixtureMissing is returned in replay mode for requests without a recorded response
+var ErrFixtureMissing = errors.New("no recorded fixture")
+
+type FixtureMode int
+
+const (
+	// FixtureRecord sends requests to the network and stores every response
+	FixtureRecord FixtureMode = iota
+	// FixtureReplay answers requests only from stored responses
+	FixtureReplay
+)
+
+// FixtureTransport is a http.RoundTripper recording responses to or replaying them from Dir
+type FixtureTransport struct {
+	Dir  string
+	Mode FixtureMode
+	// Next is used to send requests in record mode, http.DefaultTransport if nil
+	Next http.RoundTripper
+}
+
+type fixture struct {
+	Url        string `json:"url"`
+	StatusCode int    `json:"statusCode"`
+	RetryAfter string `json:"retryAfter,omitempty"`
+	Body       string `json:"body"`
+}
+
+func NewFixtureTransport(dir string, mode FixtureMode) (*FixtureTransport, error) {
+	if mode == FixtureRecord {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("fixture directory: %w", err)
+	}
+
+	return &FixtureTransport{
+		Dir:  dir,
+		Mode: mode,
+	}, nil
+}
+
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := req.URL.String()
+
+	if t.Mode == FixtureReplay {
+		return t.replay(req, u)
+	}
+
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = t.record(u, resp, body); err != nil {
+		return nil, fmt.Errorf("failed to record fixture for %s: %w", u, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func (t *FixtureTransport) record(u string, resp *http.Response, body []byte) error {
+	bts, err := json.MarshalIndent(fixture{
+		Url:        u,
+		StatusCode: resp.StatusCode,
+		RetryAfter: resp.Header.Get("Retry-After"),
+		Body:       string(body),
+	}, "", "   ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.path(u), bts, 0666)
+}
+
+func (t *FixtureTransport) replay(req *http.Request, u string) (*http.Response, error) {
+	bts, err := os.ReadFile(t.path(u))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %s in %s", ErrFixtureMissing, u, t.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f fixture
+	if err = json.Unmarshal(bts, &f); err != nil {
+		return nil, fmt.Errorf("corrupt fixture %s: %w", t.path(u), err)
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	if f.RetryAfter != "" {
+		header.Set("Retry-After", f.RetryAfter)
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(f.StatusCode) + " " + http.StatusText(f.StatusCode),
+		StatusCode:    f.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(f.Body))),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *FixtureTransport) path(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(t.Dir, hex.EncodeToString(sum[:])+".json")
+}
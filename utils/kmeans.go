@@ -5,71 +5,66 @@ package utils
 import (
 	"fmt"
 	"math"
+	"math/rand"
 
 	"github.com/sajari/regression"
 	"gonum.org/v1/plot/plotter"
 )
 
-// KMeans performs k-means clustering on the data points
-func KMeans(points []plotter.XY, k int, maxIterations int) [][]plotter.XY {
-	// guessed initial centroids; to improve
-	centroids := []plotter.XY{
-		{16, 18.5}, // Lower curve
-		{18, 14},   // Upper curve
-	}
+// Methods to choose the amount of clusters with
+const (
+	Silhouette = "silhouette"
+	BIC        = "bic"
+)
 
-	// Initialize clusters
-	clusters := make([][]plotter.XY, k)
-	for i := range clusters {
-		clusters[i] = []plotter.XY{}
+// kmeansRestarts is how often k-means is run with different seeds, the run with the lowest inertia wins
+const kmeansRestarts = 10
+
+// minSilhouette is the silhouette score below which no substantial cluster structure is assumed, so k=1 is chosen
+const minSilhouette = 0.25
+
+// KMeans performs k-means clustering on feature vectors, seeding the centroids with k-means++ drawn from rng.
+// It returns the cluster of every point; all dimensions are weighted equally, so scale them first (see Standardize).
+func KMeans(points [][]float64, k int, maxIterations int, rng *rand.Rand) []int {
+	var best []int
+	bestInertia := math.Inf(1)
+
+	for run := 0; run < kmeansRestarts; run++ {
+		labels, inertia := kmeansOnce(points, k, maxIterations, rng)
+		if inertia < bestInertia {
+			best, bestInertia = labels, inertia
+		}
 	}
+	return best
+}
+
+func kmeansOnce(points [][]float64, k int, maxIterations int, rng *rand.Rand) ([]int, float64) {
+	centroids := seedCentroids(points, k, rng)
+	labels := make([]int, len(points))
 
 	// Iterate until convergence or maximum iterations
 	for iter := 0; iter < maxIterations; iter++ {
-		// Reset clusters
-		for i := range clusters {
-			clusters[i] = []plotter.XY{}
-		}
-
 		// Assign points to nearest centroid
-		for _, point := range points {
-			minDist := math.MaxFloat64
-			clusterIdx := 0
-			for i, centroid := range centroids {
-				dist := distance(point, centroid)
-				if dist < minDist {
-					minDist = dist
-					clusterIdx = i
-				}
-			}
-			clusters[clusterIdx] = append(clusters[clusterIdx], point)
+		for i, point := range points {
+			labels[i] = nearest(point, centroids)
 		}
 
-		// Calculate new centroids
-		oldCentroids := make([]plotter.XY, k)
-		copy(oldCentroids, centroids)
-
+		// Calculate new centroids, empty clusters keep theirs
+		oldCentroids := centroids
+		centroids = make([][]float64, k)
 		for i := range centroids {
-			if len(clusters[i]) == 0 {
-				continue // Skip empty clusters
-			}
-
-			sumX, sumY := 0.0, 0.0
-			for _, point := range clusters[i] {
-				sumX += point.X
-				sumY += point.Y
-			}
-			centroids[i] = plotter.XY{
-				X: sumX / float64(len(clusters[i])),
-				Y: sumY / float64(len(clusters[i])),
+			if c := mean(points, labels, i); c != nil {
+				centroids[i] = c
+			} else {
+				centroids[i] = oldCentroids[i]
 			}
 		}
 
-		// Check for convergence
-		converged := false
+		// Converged once no centroid moves noticeably anymore
+		converged := true
 		for i := range centroids {
 			if distance(centroids[i], oldCentroids[i]) > 0.001 {
-				converged = true
+				converged = false
 				break
 			}
 		}
@@ -78,70 +73,350 @@ func KMeans(points []plotter.XY, k int, maxIterations int) [][]plotter.XY {
 		}
 	}
 
+	inertia := 0.0
+	for i, p := range points {
+		d := distance(p, centroids[labels[i]])
+		inertia += d * d
+	}
+	return labels, inertia
+}
+
+// seedCentroids picks k initial centroids with k-means++: each next centroid is drawn with a probability
+// proportional to the squared distance to the closest centroid picked so far
+func seedCentroids(points [][]float64, k int, rng *rand.Rand) [][]float64 {
+	centroids := [][]float64{points[rng.Intn(len(points))]}
+	weights := make([]float64, len(points))
+
+	for len(centroids) < k {
+		total := 0.0
+		for i, p := range points {
+			d := distance(p, centroids[nearest(p, centroids)])
+			weights[i] = d * d
+			total += weights[i]
+		}
+		if total == 0 {
+			// all points coincide with centroids already
+			centroids = append(centroids, points[rng.Intn(len(points))])
+			continue
+		}
+
+		target := rng.Float64() * total
+		i := 0
+		for ; i < len(points)-1; i++ {
+			target -= weights[i]
+			if target <= 0 {
+				break
+			}
+		}
+		centroids = append(centroids, points[i])
+	}
+	return centroids
+}
+
+func nearest(point []float64, centroids [][]float64) int {
+	minDist := math.MaxFloat64
+	idx := 0
+	for i, centroid := range centroids {
+		if dist := distance(point, centroid); dist < minDist {
+			minDist = dist
+			idx = i
+		}
+	}
+	return idx
+}
+
+// mean returns the centroid of the points labeled cluster, nil if there are none
+func mean(points [][]float64, labels []int, cluster int) []float64 {
+	var sum []float64
+	n := 0
+	for i, point := range points {
+		if labels[i] != cluster {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float64, len(point))
+		}
+		for d, v := range point {
+			sum[d] += v
+		}
+		n++
+	}
+	for d := range sum {
+		sum[d] /= float64(n)
+	}
+	return sum
+}
+
+// GroupPoints splits points into k clusters by the labels returned by KMeans or ChooseK
+func GroupPoints(points []plotter.XY, labels []int, k int) [][]plotter.XY {
+	clusters := make([][]plotter.XY, k)
+	for i, p := range points {
+		clusters[labels[i]] = append(clusters[labels[i]], p)
+	}
 	return clusters
 }
 
-// FitModels tries different regression models and returns the best one
-func FitModels(points []plotter.XY) (*regression.Regression, float64) {
-	// Try different polynomial degrees
-	bestModel := &regression.Regression{}
-	bestR2 := -1.0
+// ChooseK clusters points with every k from 1 to maxK and returns the labels of the clustering rated best by
+// method, its k and the score of every k tried (index 0 for k=1). Silhouette scores k=1 as 0, it is chosen if no
+// other k reaches minSilhouette.
+func ChooseK(points [][]float64, maxK int, maxIterations int, method string, rng *rand.Rand) ([]int, int, []float64, error) {
+	if method != Silhouette && method != BIC {
+		return nil, 0, nil, fmt.Errorf("invalid cluster method %q, expected %s or %s", method, Silhouette, BIC)
+	}
+	if len(points) == 0 {
+		return nil, 0, nil, nil
+	}
+	// clusters need a few points each to fit a curve through
+	maxK = max(1, min(maxK, len(points)/3))
+
+	var best []int
+	bestK, bestScore := 0, math.Inf(-1)
+	scores := make([]float64, maxK)
+
+	for k := 1; k <= maxK; k++ {
+		labels := KMeans(points, k, maxIterations, rng)
+		switch {
+		case method == BIC:
+			scores[k-1] = bic(points, labels, k)
+		case k == 1:
+			scores[k-1] = 0
+		default:
+			scores[k-1] = silhouette(points, labels, k)
+		}
+
+		score := scores[k-1]
+		if method == Silhouette && k == 1 {
+			score = minSilhouette
+		}
+		if score > bestScore {
+			best, bestK, bestScore = labels, k, score
+		}
+	}
+	return best, bestK, scores, nil
+}
+
+// silhouette returns the mean silhouette coefficient of all points, between -1 and 1, higher is better
+func silhouette(points [][]float64, labels []int, k int) float64 {
+	sizes := make([]int, k)
+	for _, l := range labels {
+		sizes[l]++
+	}
 
-	// Try polynomial regression with different degrees
-	for degree := 1; degree <= 4; degree++ {
-		r := &regression.Regression{}
-		r.SetObserved("Jump Distance")
+	total := 0.0
+	sums := make([]float64, k)
+	for i, p := range points {
+		own := labels[i]
+		if sizes[own] < 2 {
+			// a point alone in its cluster scores 0 by definition
+			continue
+		}
 
-		// Add feature names for each polynomial term
-		for i := 1; i <= degree; i++ {
-			name := fmt.Sprintf("x^%d", i)
-			r.SetVar(i-1, name)
+		for c := range sums {
+			sums[c] = 0
+		}
+		for j, q := range points {
+			sums[labels[j]] += distance(p, q)
 		}
 
-		// Add data points
-		for _, p := range points {
-			terms := make([]float64, degree)
-			for i := 1; i <= degree; i++ {
-				terms[i-1] = math.Pow(p.X, float64(i))
+		a := sums[own] / float64(sizes[own]-1)
+		b := math.Inf(1)
+		for c := range sums {
+			if c != own && sizes[c] > 0 {
+				b = min(b, sums[c]/float64(sizes[c]))
 			}
-			r.Train(regression.DataPoint(p.Y, terms))
+		}
+		if math.IsInf(b, 1) {
+			continue
+		}
+		if m := max(a, b); m > 0 {
+			total += (b - a) / m
+		}
+	}
+	return total / float64(len(points))
+}
+
+// bic returns the Bayesian information criterion of a clustering, assuming spherical gaussian clusters with a
+// shared variance (as in X-means). Higher is better.
+func bic(points [][]float64, labels []int, k int) float64 {
+	n := len(points)
+	dims := len(points[0])
+
+	sizes := make([]int, k)
+	for _, l := range labels {
+		sizes[l]++
+	}
+	centroids := make([][]float64, k)
+	for c := range centroids {
+		centroids[c] = mean(points, labels, c)
+	}
+
+	used := 0
+	for _, size := range sizes {
+		if size > 0 {
+			used++
+		}
+	}
+	if n <= used {
+		return math.Inf(-1)
+	}
+
+	sse := 0.0
+	for i, p := range points {
+		d := distance(p, centroids[labels[i]])
+		sse += d * d
+	}
+	variance := sse / float64(dims*(n-used))
+	if variance <= 0 {
+		variance = math.SmallestNonzeroFloat64
+	}
+
+	logL := -float64(n*dims)/2*math.Log(2*math.Pi*variance) - float64(dims*(n-used))/2
+	for _, size := range sizes {
+		if nc := float64(size); nc > 0 {
+			logL += nc * math.Log(nc/float64(n))
+		}
+	}
+
+	params := float64((used - 1) + used*dims + 1)
+	return logL - params/2*math.Log(float64(n))
+}
+
+// Methods to choose the polynomial degree with
+const (
+	SelectCV    = "cv"
+	SelectAdjR2 = "adjr2"
+	SelectAIC   = "aic"
+)
+
+// cvFolds is the amount of folds of the cross-validation
+const cvFolds = 5
+
+// ModelFit tells how well a fitted model generalizes
+type ModelFit struct {
+	Degree int
+	// Method the degree was selected by, Score is its value: the cross-validated RMSE (lower is better),
+	// the adjusted R² (higher is better) or the AIC (lower is better)
+	Method string
+	Score  float64
+}
+
+// FitModels fits polynomials of degree 1 to maxDegree with the given fit method (see FitPolynomial) and returns the
+// one rated best by method. Training R² always favours the highest degree, so it is not used to choose.
+// anchors are synthetic points every fit is trained on, but which are neither validated nor counted as observations;
+// R² and the scores only cover points.
+func FitModels(points []plotter.XY, anchors []plotter.XY, maxDegree int, method string, fit string, rng *rand.Rand) (*Polynomial, ModelFit, error) {
+	var bestModel *Polynomial
+	best := ModelFit{Method: method}
+
+	for degree := 1; degree <= maxDegree; degree++ {
+		// a fit needs more points than coefficients, otherwise it is exact and says nothing
+		if len(points)+len(anchors) <= degree+1 {
+			break
 		}
 
-		// Fit the model
-		err := r.Run()
+		model, err := FitPolynomial(points, anchors, degree, fit, rng)
 		if err != nil {
 			continue
 		}
+		model = NewPolynomial(model.Coeffs, points)
 
-		// Get R-squared value
-		r2 := r.R2
-		if r2 > bestR2 {
-			bestR2 = r2
-			bestModel = r
+		var score float64
+		switch method {
+		case SelectCV:
+			score, err = crossValidate(points, anchors, degree, fit, rng)
+			if err != nil {
+				continue
+			}
+		case SelectAdjR2:
+			n, p := float64(len(points)), float64(degree)
+			if n-p-1 <= 0 {
+				continue
+			}
+			score = 1 - (1-model.R2)*(n-1)/(n-p-1)
+		case SelectAIC:
+			n := float64(len(points))
+			sse := model.sse(points)
+			score = n*math.Log(max(sse, math.SmallestNonzeroFloat64)/n) + 2*float64(degree+2)
+		default:
+			return nil, best, fmt.Errorf("invalid degree selection %q, expected %s, %s or %s", method, SelectCV, SelectAdjR2, SelectAIC)
+		}
+
+		higherIsBetter := method == SelectAdjR2
+		if bestModel == nil || (higherIsBetter && score > best.Score) || (!higherIsBetter && score < best.Score) {
+			bestModel = model
+			best.Degree = degree
+			best.Score = score
+		}
+	}
+
+	if bestModel == nil {
+		return nil, best, fmt.Errorf("failed to fit a curve through %d points", len(points))
+	}
+	return bestModel, best, nil
+}
+
+// fitLeastSquares fits a polynomial by ordinary least squares
+func fitLeastSquares(points []plotter.XY, degree int) (*Polynomial, error) {
+	r := &regression.Regression{}
+	r.SetObserved("Jump Distance")
+
+	// Add feature names for each polynomial term
+	for i := 1; i <= degree; i++ {
+		r.SetVar(i-1, fmt.Sprintf("x^%d", i))
+	}
+
+	// Add data points
+	for _, p := range points {
+		terms := make([]float64, degree)
+		for i := 1; i <= degree; i++ {
+			terms[i-1] = math.Pow(p.X, float64(i))
 		}
+		r.Train(regression.DataPoint(p.Y, terms))
 	}
 
-	return bestModel, bestR2
+	if err := r.Run(); err != nil {
+		return nil, err
+	}
+	return NewPolynomial(r.GetCoeffs(), points), nil
+}
+
+// crossValidate returns the root mean squared error of predicting every point by a polynomial fitted without it,
+// using cvFolds folds (or leave-one-out for fewer points). anchors are part of every training set.
+func crossValidate(points []plotter.XY, anchors []plotter.XY, degree int, fit string, rng *rand.Rand) (float64, error) {
+	folds := min(cvFolds, len(points))
+
+	sse := 0.0
+	for fold := 0; fold < folds; fold++ {
+		var train, test []plotter.XY
+		for i, p := range points {
+			if i%folds == fold {
+				test = append(test, p)
+			} else {
+				train = append(train, p)
+			}
+		}
+		if len(train)+len(anchors) <= degree+1 {
+			return 0, fmt.Errorf("too few points for %d folds", folds)
+		}
+
+		model, err := FitPolynomial(train, anchors, degree, fit, rng)
+		if err != nil {
+			return 0, err
+		}
+		sse += model.sse(test)
+	}
+	return math.Sqrt(sse / float64(len(points))), nil
 }
 
 // EvaluateModel predicts y values for a range of x values using the given model
-func EvaluateModel(model *regression.Regression, minX, maxX float64, points int) ([]Point, error) {
+func EvaluateModel(model *Polynomial, minX, maxX float64, points int) []Point {
 	results := make([]Point, points)
 	step := (maxX - minX) / float64(points-1)
 
-	degree := len(model.GetCoeffs())
 	for i := 0; i < points; i++ {
 		x := minX + float64(i)*step
-		terms := make([]float64, degree)
-		for j := 1; j <= degree; j++ {
-			terms[j-1] = math.Pow(x, float64(j))
-		}
-		y, err := model.Predict(terms)
-		if err != nil {
-			return nil, err
-		}
-		results[i] = Point{X: x, Y: y}
+		results[i] = Point{X: x, Y: model.Predict(x)}
 	}
 
-	return results, nil
+	return results
 }
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// blobs returns n points scattered around each center, spread being the largest offset in any dimension
+func blobs(rng *rand.Rand, n int, spread float64, centers ...[]float64) ([][]float64, []int) {
+	var points [][]float64
+	var labels []int
+	for c, center := range centers {
+		for i := 0; i < n; i++ {
+			p := make([]float64, len(center))
+			for d := range p {
+				p[d] = center[d] + (rng.Float64()*2-1)*spread
+			}
+			points = append(points, p)
+			labels = append(labels, c)
+		}
+	}
+	return points, labels
+}
+
+// sameGrouping reports whether two labelings split the points the same way, whatever the cluster numbers
+func sameGrouping(a, b []int) bool {
+	ab, ba := make(map[int]int), make(map[int]int)
+	for i := range a {
+		if l, ok := ab[a[i]]; ok && l != b[i] {
+			return false
+		}
+		if l, ok := ba[b[i]]; ok && l != a[i] {
+			return false
+		}
+		ab[a[i]], ba[b[i]] = b[i], a[i]
+	}
+	return true
+}
+
+func TestKMeans(t *testing.T) {
+	tests := []struct {
+		name    string
+		centers [][]float64
+	}{
+		{"two", [][]float64{{0, 0}, {10, 10}}},
+		{"three", [][]float64{{0, 0}, {10, 0}, {5, 10}}},
+		{"four", [][]float64{{0, 0}, {10, 0}, {0, 10}, {10, 10}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			points, want := blobs(rng, 20, 1, tt.centers...)
+
+			got := KMeans(points, len(tt.centers), 100, rng)
+			if !sameGrouping(got, want) {
+				t.Errorf("KMeans() = %v, want the grouping of %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChooseK(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		centers [][]float64
+		want    int
+	}{
+		{"silhouette two blobs", Silhouette, [][]float64{{0, 0}, {10, 10}}, 2},
+		{"silhouette three blobs", Silhouette, [][]float64{{0, 0}, {10, 0}, {5, 10}}, 3},
+		{"bic one blob", BIC, [][]float64{{0, 0}}, 1},
+		{"bic two blobs", BIC, [][]float64{{0, 0}, {10, 10}}, 2},
+		{"bic three blobs", BIC, [][]float64{{0, 0}, {10, 0}, {5, 10}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			points, want := blobs(rng, 20, 1, tt.centers...)
+
+			labels, k, scores, err := ChooseK(points, 4, 100, tt.method, rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k != tt.want {
+				t.Fatalf("ChooseK() k = %d, want %d (scores %v)", k, tt.want, scores)
+			}
+			if len(scores) != 4 {
+				t.Errorf("ChooseK() returned %d scores, want one for every k", len(scores))
+			}
+			if !sameGrouping(labels, want) {
+				t.Errorf("ChooseK() labels = %v, want the grouping of %v", labels, want)
+			}
+		})
+	}
+
+	if _, _, _, err := ChooseK(nil, 4, 100, "elbow", rand.New(rand.NewSource(1))); err == nil {
+		t.Error("ChooseK() accepted an invalid method")
+	}
+}
+
+func TestSilhouette(t *testing.T) {
+	points := [][]float64{{0}, {1}, {10}, {11}}
+	tests := []struct {
+		name   string
+		labels []int
+		want   float64
+	}{
+		// a = 1 for every point, b = 10.5 for the outer and 9.5 for the inner ones
+		{"separated", []int{0, 0, 1, 1}, ((10.5-1)/10.5 + (9.5-1)/9.5) / 2},
+		// a = 10 for every point, b = 6 for the outer and 5 for the inner ones
+		{"mixed up", []int{0, 1, 0, 1}, ((6-10)/10.0 + (5-10)/10.0) / 2},
+		// the single points score 0, the pair has a = 1 and b = 9 or 10
+		{"singletons", []int{0, 1, 2, 2}, ((9-1)/9.0 + (10-1)/10.0) / 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := 0
+			for _, l := range tt.labels {
+				k = max(k, l+1)
+			}
+			if got := silhouette(points, tt.labels, k); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("silhouette() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBIC(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	points, labels := blobs(rng, 20, 1, []float64{0, 0}, []float64{10, 10})
+
+	single := make([]int, len(points))
+	mixed := make([]int, len(points))
+	for i := range mixed {
+		mixed[i] = i % 2
+	}
+
+	right := bic(points, labels, 2)
+	if one := bic(points, single, 1); right <= one {
+		t.Errorf("bic() of the right split %v is not above the one of a single cluster %v", right, one)
+	}
+	if wrong := bic(points, mixed, 2); right <= wrong {
+		t.Errorf("bic() of the right split %v is not above the one of a wrong split %v", right, wrong)
+	}
+	if got := bic(points[:2], []int{0, 1}, 2); !math.IsInf(got, -1) {
+		t.Errorf("bic() with a cluster per point = %v, want -Inf", got)
+	}
+}
+
+// noisyCurve returns points on the polynomial every half NJS from 8 to 26, moved up or down by up to noise
+func noisyCurve(rng *rand.Rand, coeffs []float64, noise float64) []plotter.XY {
+	points := curve(coeffs, 8, 26, 0.5)
+	for i := range points {
+		points[i].Y += (rng.Float64()*2 - 1) * noise
+	}
+	return points
+}
+
+func TestFitModelsSelectsDegree(t *testing.T) {
+	// every criterion overfits some noise by chance (adjusted R² most often), so the choices are counted over many
+	// samples of a noisy quadratic: degree 2 has to win, and MaxDegree may only rarely be chosen
+	const maxDegree, samples = 4, 100
+	quadratic := []float64{14, -0.4, 0.03}
+
+	tests := []struct {
+		name    string
+		method  string
+		anchors []plotter.XY
+	}{
+		{"cv", SelectCV, nil},
+		{"adjr2", SelectAdjR2, nil},
+		{"aic", SelectAIC, nil},
+		{"cv with anchor", SelectCV, []plotter.XY{{}}},
+		{"adjr2 with anchor", SelectAdjR2, []plotter.XY{{}}},
+		{"aic with anchor", SelectAIC, []plotter.XY{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coeffs := slices.Clone(quadratic)
+			if tt.anchors != nil {
+				// the anchor only fits a curve through the origin
+				coeffs[0] = 0
+			}
+
+			chosen := make([]int, maxDegree+1)
+			for seed := int64(1); seed <= samples; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+				model, fit, err := FitModels(noisyCurve(rng, coeffs, 0.3), tt.anchors, maxDegree, tt.method, FitOLS, rng)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fit.Method != tt.method || model.Degree() != fit.Degree {
+					t.Fatalf("FitModels() = %s, %+v", model.Formula, fit)
+				}
+				chosen[fit.Degree]++
+			}
+
+			if chosen[1] > 0 {
+				t.Errorf("FitModels() chose degree 1 for a quadratic %d times", chosen[1])
+			}
+			if slices.Max(chosen) != chosen[2] || chosen[maxDegree]*2 >= chosen[2] {
+				t.Errorf("FitModels() chose the degrees %v times, want mostly 2", chosen)
+			}
+		})
+	}
+}
+
+func TestFitModelsLeavesAnchorsOut(t *testing.T) {
+	// flat points the anchor pulls the curve away from, counting it would make the curve look good
+	rng := rand.New(rand.NewSource(1))
+	points := noisyCurve(rng, []float64{10}, 0.3)
+	anchors := []plotter.XY{{}}
+	n := float64(len(points))
+
+	for _, method := range []string{SelectCV, SelectAdjR2, SelectAIC} {
+		t.Run(method, func(t *testing.T) {
+			model, fit, err := FitModels(points, anchors, 1, method, FitOLS, rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := NewPolynomial(model.Coeffs, points).R2; model.R2 != want {
+				t.Errorf("R2 = %v, want %v measured on the points only", model.R2, want)
+			}
+			if counted := NewPolynomial(model.Coeffs, append(slices.Clone(points), anchors...)).R2; counted <= model.R2 {
+				t.Fatalf("R2 with the anchor %v is not above the one without %v, the test data doesn't tell them apart", counted, model.R2)
+			}
+
+			var want float64
+			switch method {
+			case SelectCV:
+				want, err = crossValidate(points, anchors, 1, FitOLS, rng)
+				if err != nil {
+					t.Fatal(err)
+				}
+			case SelectAdjR2:
+				want = 1 - (1-model.R2)*(n-1)/(n-1-1)
+			case SelectAIC:
+				want = n*math.Log(model.sse(points)/n) + 2*3
+			}
+			if math.Abs(fit.Score-want) > 1e-9 {
+				t.Errorf("score = %v, want %v from %v observations", fit.Score, want, n)
+			}
+		})
+	}
+}
+
+func TestCrossValidate(t *testing.T) {
+	// leave-one-out on three flat points, each line fitted through the anchor and the two others:
+	// the left out points are missed by 4/7, 1/7 and 2/3
+	points := []plotter.XY{{X: 1, Y: 1}, {X: 2, Y: 1}, {X: 3, Y: 1}}
+	want := math.Sqrt((16.0/49 + 1.0/49 + 4.0/9) / 3)
+
+	got, err := crossValidate(points, []plotter.XY{{}}, 1, FitOLS, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("crossValidate() = %v, want %v", got, want)
+	}
+}
+
+func TestFitModelsTooFewPoints(t *testing.T) {
+	anchors := []plotter.XY{{}}
+	tests := []struct {
+		name   string
+		points []plotter.XY
+		method string
+	}{
+		{"no points", nil, SelectCV},
+		{"one point", []plotter.XY{{X: 10, Y: 15}}, SelectAIC},
+		// every fold trains on the anchor and a single point
+		{"two points cv", []plotter.XY{{X: 10, Y: 15}, {X: 20, Y: 18}}, SelectCV},
+		// no degrees of freedom left for the adjusted R²
+		{"two points adjr2", []plotter.XY{{X: 10, Y: 15}, {X: 20, Y: 18}}, SelectAdjR2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if model, _, err := FitModels(tt.points, anchors, 4, tt.method, FitOLS, rand.New(rand.NewSource(1))); err == nil {
+				t.Errorf("FitModels() = %s, want an error", model.Formula)
+			}
+		})
+	}
+
+	if _, _, err := FitModels(noisyCurve(rand.New(rand.NewSource(1)), []float64{10, 0.5}, 0.3), nil, 2, "r2", FitOLS, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("FitModels() accepted an invalid degree selection")
+	}
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// mixtureRestarts is how often EM is run; the first run starts from the given labels, the others from random ones
+const mixtureRestarts = 5
+
+// minVariance keeps a curve through almost identical points from collapsing to zero variance
+const minVariance = 1e-4
+
+// Mixture is a mixture of polynomial regressions: every point belongs to each curve with some probability
+type Mixture struct {
+	Components []MixtureComponent
+	// Memberships holds for every point the probability of belonging to each component
+	Memberships   [][]float64
+	LogLikelihood float64
+}
+
+type MixtureComponent struct {
+	// Weight is the share of points belonging to the component
+	Weight float64
+	// Coeffs of the polynomial, starting with the intercept
+	Coeffs   []float64
+	Variance float64
+}
+
+// Labels returns the most likely component of every point
+func (m *Mixture) Labels() []int {
+	labels := make([]int, len(m.Memberships))
+	for i, r := range m.Memberships {
+		for c := range r {
+			if r[c] > r[labels[i]] {
+				labels[i] = c
+			}
+		}
+	}
+	return labels
+}
+
+// BIC returns the Bayesian information criterion of the mixture fitted to n points, higher is better
+func (m *Mixture) BIC() float64 {
+	k := len(m.Components)
+	params := k*len(m.Components[0].Coeffs) + k + (k - 1)
+	return m.LogLikelihood - float64(params)/2*math.Log(float64(len(m.Memberships)))
+}
+
+// MixtureOfRegressions fits k polynomial curves of the given degree to points with expectation maximization, so
+// every point ends up with the curve it fits best instead of the centroid it lies closest to. The first run starts
+// from init (e.g. k-means labels), further runs from random labels drawn from rng; the most likely fit wins.
+func MixtureOfRegressions(points []plotter.XY, init []int, k int, degree int, maxIterations int, rng *rand.Rand) (*Mixture, error) {
+	if len(points) == 0 {
+		return nil, errors.New("no points to fit")
+	}
+
+	var best *Mixture
+	labels := init
+	for run := 0; run < mixtureRestarts; run++ {
+		if run > 0 {
+			labels = make([]int, len(points))
+			for i := range labels {
+				labels[i] = rng.Intn(k)
+			}
+		}
+		m := fitMixture(points, labels, k, degree, maxIterations)
+		if m != nil && (best == nil || m.LogLikelihood > best.LogLikelihood) {
+			best = m
+		}
+	}
+	if best == nil {
+		return nil, errors.New("mixture of regressions did not converge")
+	}
+	return best, nil
+}
+
+func fitMixture(points []plotter.XY, labels []int, k int, degree int, maxIterations int) *Mixture {
+	m := &Mixture{
+		Components:    make([]MixtureComponent, k),
+		Memberships:   make([][]float64, len(points)),
+		LogLikelihood: math.Inf(-1),
+	}
+	for i := range points {
+		m.Memberships[i] = make([]float64, k)
+		m.Memberships[i][labels[i]] = 1
+	}
+
+	for iter := 0; iter < maxIterations; iter++ {
+		// M-step: weighted fit of every curve to the points it is responsible for
+		for c := range m.Components {
+			weights := make([]float64, len(points))
+			total := 0.0
+			for i := range points {
+				weights[i] = m.Memberships[i][c]
+				total += weights[i]
+			}
+
+			coeffs, ok := weightedPolyFit(points, weights, degree)
+			if !ok {
+				if m.Components[c].Coeffs == nil {
+					return nil
+				}
+				// keep the previous curve of a component that lost (almost) all its points
+				coeffs = m.Components[c].Coeffs
+			}
+
+			variance := 0.0
+			for i, p := range points {
+				r := p.Y - evalPoly(coeffs, p.X)
+				variance += weights[i] * r * r
+			}
+			if total > 0 {
+				variance /= total
+			}
+			m.Components[c] = MixtureComponent{
+				Weight:   total / float64(len(points)),
+				Coeffs:   coeffs,
+				Variance: max(variance, minVariance),
+			}
+		}
+
+		// E-step: probability of every point belonging to each curve
+		ll := 0.0
+		logs := make([]float64, k)
+		for i, p := range points {
+			maxLog := math.Inf(-1)
+			for c, comp := range m.Components {
+				r := p.Y - evalPoly(comp.Coeffs, p.X)
+				logs[c] = math.Log(comp.Weight) - 0.5*math.Log(2*math.Pi*comp.Variance) - r*r/(2*comp.Variance)
+				maxLog = max(maxLog, logs[c])
+			}
+			sum := 0.0
+			for c := range logs {
+				sum += math.Exp(logs[c] - maxLog)
+			}
+			for c := range logs {
+				m.Memberships[i][c] = math.Exp(logs[c]-maxLog) / sum
+			}
+			ll += maxLog + math.Log(sum)
+		}
+
+		converged := math.Abs(ll-m.LogLikelihood) < 1e-6*math.Abs(ll)
+		m.LogLikelihood = ll
+		if converged {
+			break
+		}
+	}
+	return m
+}
+
+// weightedPolyFit solves the weighted least squares fit of a polynomial, ok is false if the points don't determine it
+func weightedPolyFit(points []plotter.XY, weights []float64, degree int) ([]float64, bool) {
+	terms := degree + 1
+
+	// normal equations (XᵀWX) β = XᵀWy, the last column holds the right hand side
+	a := make([][]float64, terms)
+	for r := range a {
+		a[r] = make([]float64, terms+1)
+	}
+	total := 0.0
+	pows := make([]float64, terms)
+	for i, p := range points {
+		w := weights[i]
+		if w == 0 {
+			continue
+		}
+		total += w
+		pows[0] = 1
+		for d := 1; d < terms; d++ {
+			pows[d] = pows[d-1] * p.X
+		}
+		for r := 0; r < terms; r++ {
+			for c := 0; c < terms; c++ {
+				a[r][c] += w * pows[r] * pows[c]
+			}
+			a[r][terms] += w * pows[r] * p.Y
+		}
+	}
+	if total < float64(terms) {
+		return nil, false
+	}
+
+	// gaussian elimination with partial pivoting
+	for col := 0; col < terms; col++ {
+		pivot := col
+		for r := col + 1; r < terms; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for r := col + 1; r < terms; r++ {
+			f := a[r][col] / a[col][col]
+			for c := col; c <= terms; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+		}
+	}
+
+	coeffs := make([]float64, terms)
+	for r := terms - 1; r >= 0; r-- {
+		sum := a[r][terms]
+		for c := r + 1; c < terms; c++ {
+			sum -= a[r][c] * coeffs[c]
+		}
+		coeffs[r] = sum / a[r][r]
+	}
+	return coeffs, true
+}
+
+func evalPoly(coeffs []float64, x float64) float64 {
+	y := 0.0
+	for d := len(coeffs) - 1; d >= 0; d-- {
+		y = y*x + coeffs[d]
+	}
+	return y
+}
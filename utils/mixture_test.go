@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// curve returns points on the polynomial with the given coefficients at x = from, from+step, ... up to to
+func curve(coeffs []float64, from, to, step float64) []plotter.XY {
+	var points []plotter.XY
+	for x := from; x <= to+1e-9; x += step {
+		points = append(points, plotter.XY{X: x, Y: evalPoly(coeffs, x)})
+	}
+	return points
+}
+
+func TestWeightedPolyFit(t *testing.T) {
+	tests := []struct {
+		name    string
+		points  []plotter.XY
+		weights []float64
+		degree  int
+		want    []float64
+		ok      bool
+	}{
+		{"line", curve([]float64{2, 0.5}, 0, 10, 1), nil, 1, []float64{2, 0.5}, true},
+		{"quadratic", curve([]float64{12, -0.8, 0.04}, 8, 24, 0.5), nil, 2, []float64{12, -0.8, 0.04}, true},
+		{"cubic", curve([]float64{1, -2, 0.3, -0.01}, -5, 15, 1), nil, 3, []float64{1, -2, 0.3, -0.01}, true},
+		{
+			name:    "zero weights ignore points",
+			points:  append(curve([]float64{3, 1}, 0, 5, 1), plotter.XY{X: 2, Y: 100}),
+			weights: []float64{1, 1, 1, 1, 1, 1, 0},
+			degree:  1,
+			want:    []float64{3, 1},
+			ok:      true,
+		},
+		{"same x", []plotter.XY{{X: 4, Y: 1}, {X: 4, Y: 2}, {X: 4, Y: 3}}, nil, 1, nil, false},
+		{"too few points", curve([]float64{1, 1}, 0, 1, 1), nil, 2, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights := tt.weights
+			if weights == nil {
+				weights = ones(len(tt.points))
+			}
+
+			got, ok := weightedPolyFit(tt.points, weights, tt.degree)
+			if ok != tt.ok {
+				t.Fatalf("weightedPolyFit() ok = %v, want %v", ok, tt.ok)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("weightedPolyFit() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-6 {
+					t.Errorf("weightedPolyFit() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestMixtureOfRegressions(t *testing.T) {
+	// two crossing lines, which k-means on NJS and JD can't tell apart around the crossing
+	lines := [][]float64{{10, 0.5}, {30, -0.5}}
+
+	tests := []struct {
+		name string
+		init func(n int) []int
+	}{
+		{"from the right labels", func(n int) []int {
+			labels := make([]int, n)
+			for i := n / 2; i < n; i++ {
+				labels[i] = 1
+			}
+			return labels
+		}},
+		{"from alternating labels", func(n int) []int {
+			labels := make([]int, n)
+			for i := range labels {
+				labels[i] = i % 2
+			}
+			return labels
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			var points []plotter.XY
+			var want []int
+			for c, coeffs := range lines {
+				for _, p := range curve(coeffs, 1, 39, 2) {
+					p.Y += (rng.Float64()*2 - 1) * 0.2
+					points = append(points, p)
+					want = append(want, c)
+				}
+			}
+
+			m, err := MixtureOfRegressions(points, tt.init(len(points)), 2, 1, 200, rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			labels := m.Labels()
+			first := []int{labels[0], labels[len(points)/2]}
+			if first[0] == first[1] {
+				t.Fatalf("Labels() = %v, want the lines apart", labels)
+			}
+			for i := range points {
+				// at the crossing both lines fit equally well
+				if math.Abs(points[i].X-20) > 2 && labels[i] != first[want[i]] {
+					t.Errorf("Labels() = %v, want the points of each line together", labels)
+					break
+				}
+			}
+
+			for _, comp := range m.Components {
+				found := false
+				for _, coeffs := range lines {
+					if math.Abs(comp.Coeffs[0]-coeffs[0]) < 0.5 && math.Abs(comp.Coeffs[1]-coeffs[1]) < 0.05 {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("component %v is none of the lines %v", comp.Coeffs, lines)
+				}
+				if math.Abs(comp.Weight-0.5) > 0.05 {
+					t.Errorf("component weight = %v, want 0.5", comp.Weight)
+				}
+			}
+		})
+	}
+}
+
+func TestMixtureOfRegressionsWithoutPoints(t *testing.T) {
+	if _, err := MixtureOfRegressions(nil, nil, 2, 1, 200, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("MixtureOfRegressions() fitted no points")
+	}
+}
+
+func TestMixtureBIC(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var points []plotter.XY
+	for _, p := range curve([]float64{10, 0.5}, 1, 39, 1) {
+		p.Y += (rng.Float64()*2 - 1) * 0.2
+		points = append(points, p)
+	}
+	init := make([]int, len(points))
+	for i := range init {
+		init[i] = i % 2
+	}
+
+	one, err := MixtureOfRegressions(points, make([]int, len(points)), 1, 1, 200, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := MixtureOfRegressions(points, init, 2, 1, 200, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.BIC() <= two.BIC() {
+		t.Errorf("BIC() of one line %v is not above the one of two lines %v on points of a single line", one.BIC(), two.BIC())
+	}
+}
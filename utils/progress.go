@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// ProgressBar draws a single, redrawn line with progress and ETA, meant for terminals
+type ProgressBar struct {
+	w     io.Writer
+	label string
+	start time.Time
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func NewProgressBar(w io.Writer, label string) *ProgressBar {
+	return &ProgressBar{
+		w:     w,
+		label: label,
+		start: time.Now(),
+	}
+}
+
+// Update redraws the bar, at most ten times a second unless done reached total
+func (b *ProgressBar) Update(done, total int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if done < total && now.Sub(b.last) < 100*time.Millisecond {
+		return
+	}
+	b.last = now
+
+	ratio := 1.0
+	if total > 0 {
+		ratio = float64(done) / float64(total)
+	}
+	filled := int(ratio * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+
+	eta := "--:--"
+	if done > 0 {
+		remaining := time.Duration(float64(now.Sub(b.start)) / float64(done) * float64(total-done))
+		eta = formatDuration(remaining)
+	}
+
+	// \x1b[K clears what is left of a previous, longer line
+	_, _ = fmt.Fprintf(b.w, "\r%s [%s] %d/%d %3.0f%% ETA %s\x1b[K", b.label, bar, done, total, ratio*100, eta)
+}
+
+// Finish ends the line of the bar, so following output starts on a new one
+func (b *ProgressBar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		_, _ = fmt.Fprintln(b.w)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
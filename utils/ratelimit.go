@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit describes a token bucket refilling Rate tokens per second up to Burst tokens
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+var DefaultRateLimits = map[string]RateLimit{
+	"scoresaber.com":                {Rate: 6, Burst: 6},
+	"api.beatleader.com":            {Rate: 10, Burst: 10},
+	"cdn.scorestats.beatleader.com": {Rate: 20, Burst: 20},
+}
+
+// fallbackRateLimit is used for hosts without an entry in the limits of a HostLimiter
+var fallbackRateLimit = RateLimit{Rate: 5, Burst: 5}
+
+// TokenBucket is a token bucket rate limiter that can additionally be paused, e.g. after a 429 response
+type TokenBucket struct {
+	mu           sync.Mutex
+	limit        RateLimit
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func NewTokenBucket(limit RateLimit) *TokenBucket {
+	return &TokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		delay := b.reserve()
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause stops handing out tokens for d
+func (b *TokenBucket) Pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+// reserve takes a token if possible and otherwise returns how long to wait before trying again
+func (b *TokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// HostLimiter keeps a separate TokenBucket per host
+type HostLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]RateLimit
+	buckets map[string]*TokenBucket
+}
+
+func NewHostLimiter(limits map[string]RateLimit) *HostLimiter {
+	return &HostLimiter{
+		limits:  limits,
+		buckets: make(map[string]*TokenBucket),
+	}
+}
+
+var DefaultLimiter = NewHostLimiter(DefaultRateLimits)
+
+// Wait blocks until a request to u may be sent
+func (l *HostLimiter) Wait(ctx context.Context, u string) error {
+	return l.bucket(HostOf(u)).Wait(ctx)
+}
+
+// Pause stops all requests to the host of u for d
+func (l *HostLimiter) Pause(u string, d time.Duration) {
+	l.bucket(HostOf(u)).Pause(d)
+}
+
+func (l *HostLimiter) bucket(host string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[host]
+	if !ok {
+		limit, ok := l.limits[host]
+		if !ok {
+			limit = fallbackRateLimit
+		}
+		b = NewTokenBucket(limit)
+		l.buckets[host] = b
+	}
+	return b
+}
+
+// RetryAfter parses the Retry-After header of resp, which holds either seconds or a http date
+func RetryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return fallback
+}
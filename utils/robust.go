@@ -0,0 +1,331 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// Methods to fit a polynomial with
+const (
+	// FitOLS is ordinary least squares, every play pulls on the curve with the square of its distance
+	FitOLS = "ols"
+	// FitHuber weighs down plays far from the curve (iteratively reweighted least squares with the Huber loss)
+	FitHuber = "huber"
+	// FitTheilSen takes the median of the curves through many small subsets of plays
+	FitTheilSen = "theilsen"
+	// FitRANSAC fits the curve to the largest set of plays that agree with each other and ignores the rest
+	FitRANSAC = "ransac"
+)
+
+const (
+	// huberK is the usual tuning constant giving 95% efficiency on normally distributed residuals
+	huberK          = 1.345
+	huberIterations = 50
+	// theilSenSamples is the amount of random subsets used once there are too many to try all
+	theilSenSamples = 3000
+	// theilSenMinKnots is the least amount of NJS knots curves of degree 2 and up are fitted through
+	theilSenMinKnots = 9
+	ransacSamples    = 300
+	// ransacThreshold is how many robust standard deviations a play may be off the curve to count as agreeing
+	ransacThreshold = 2.5
+)
+
+// Polynomial is a fitted JD curve
+type Polynomial struct {
+	// Coeffs start with the intercept
+	Coeffs []float64
+	// R2 is measured on the points the curve was fitted to, outliers included
+	R2      float64
+	Formula string
+}
+
+// NewPolynomial creates the polynomial with the given coefficients, measuring its R² on points
+func NewPolynomial(coeffs []float64, points []plotter.XY) *Polynomial {
+	p := &Polynomial{Coeffs: coeffs}
+
+	p.Formula = fmt.Sprintf("Predicted = %.4f", coeffs[0])
+	for i, c := range coeffs[1:] {
+		p.Formula += fmt.Sprintf(" + x^%d*%.4f", i+1, c)
+	}
+
+	ys := make([]float64, len(points))
+	for i, pt := range points {
+		ys[i] = pt.Y
+	}
+	avg := meanOf(ys)
+	total := 0.0
+	for _, y := range ys {
+		total += (y - avg) * (y - avg)
+	}
+	if total > 0 {
+		p.R2 = 1 - p.sse(points)/total
+	}
+	return p
+}
+
+func (p *Polynomial) Predict(x float64) float64 {
+	return evalPoly(p.Coeffs, x)
+}
+
+func (p *Polynomial) Degree() int {
+	return len(p.Coeffs) - 1
+}
+
+// sse returns the sum of squared errors of the polynomial on points
+func (p *Polynomial) sse(points []plotter.XY) float64 {
+	sum := 0.0
+	for _, pt := range points {
+		r := pt.Y - p.Predict(pt.X)
+		sum += r * r
+	}
+	return sum
+}
+
+// FitPolynomial fits a polynomial of the given degree to points and anchors with one of the Fit* methods; rng is used
+// by the methods drawing random subsets. anchors are synthetic points fitted alongside points, which RANSAC never drops.
+func FitPolynomial(points []plotter.XY, anchors []plotter.XY, degree int, method string, rng *rand.Rand) (*Polynomial, error) {
+	all := append(slices.Clip(points), anchors...)
+	switch method {
+	case FitOLS:
+		return fitLeastSquares(all, degree)
+	case FitHuber:
+		return fitHuber(all, degree)
+	case FitTheilSen:
+		return fitTheilSen(all, degree, rng)
+	case FitRANSAC:
+		return fitRANSAC(points, anchors, degree, rng)
+	}
+	return nil, fmt.Errorf("invalid fit %q, expected %s, %s, %s or %s", method, FitOLS, FitHuber, FitTheilSen, FitRANSAC)
+}
+
+func fitHuber(points []plotter.XY, degree int) (*Polynomial, error) {
+	model, err := fitLeastSquares(points, degree)
+	if err != nil {
+		return nil, err
+	}
+
+	coeffs := model.Coeffs
+	weights := make([]float64, len(points))
+	for iter := 0; iter < huberIterations; iter++ {
+		scale := residualScale(points, coeffs)
+		if scale == 0 {
+			break
+		}
+		for i, p := range points {
+			r := math.Abs(p.Y - evalPoly(coeffs, p.X))
+			weights[i] = 1
+			if r > huberK*scale {
+				weights[i] = huberK * scale / r
+			}
+		}
+
+		next, ok := weightedPolyFit(points, weights, degree)
+		if !ok {
+			break
+		}
+		converged := true
+		for i := range next {
+			if math.Abs(next[i]-coeffs[i]) > 1e-8*(1+math.Abs(coeffs[i])) {
+				converged = false
+			}
+		}
+		coeffs = next
+		if converged {
+			break
+		}
+	}
+	return NewPolynomial(coeffs, points), nil
+}
+
+// fitTheilSen generalizes Theil–Sen to polynomials: it fits the exact curve through every subset of degree+1 points
+// (or theilSenSamples random ones). Lines take the median slope and intercept; higher degrees take the median
+// prediction at fixed NJS knots and fit the curve through those, as medians of single coefficients don't make a curve
+// when the coefficients of each subset depend on each other.
+func fitTheilSen(points []plotter.XY, degree int, rng *rand.Rand) (*Polynomial, error) {
+	terms := degree + 1
+	var curves [][]float64
+
+	add := func(subset []plotter.XY) {
+		coeffs, ok := weightedPolyFit(subset, ones(len(subset)), degree)
+		if !ok {
+			// points sharing an NJS don't determine a curve
+			return
+		}
+		curves = append(curves, coeffs)
+	}
+
+	if degree == 1 && len(points)*(len(points)-1)/2 <= theilSenSamples {
+		for i := range points {
+			for j := i + 1; j < len(points); j++ {
+				add([]plotter.XY{points[i], points[j]})
+			}
+		}
+	} else {
+		for s := 0; s < theilSenSamples; s++ {
+			add(sample(points, terms, rng))
+		}
+	}
+	if len(curves) == 0 {
+		return nil, errors.New("no subset of the points determines a curve")
+	}
+
+	if degree == 1 {
+		coeffs := make([]float64, terms)
+		values := make([]float64, len(curves))
+		for d := range coeffs {
+			for i, c := range curves {
+				values[i] = c[d]
+			}
+			coeffs[d] = median(values)
+		}
+		return NewPolynomial(coeffs, points), nil
+	}
+
+	knots := theilSenKnots(points, terms)
+	predictions := make([]float64, len(curves))
+	for i, k := range knots {
+		for j, c := range curves {
+			predictions[j] = evalPoly(c, k.X)
+		}
+		knots[i].Y = median(predictions)
+	}
+	coeffs, ok := weightedPolyFit(knots, ones(len(knots)), degree)
+	if !ok {
+		return nil, errors.New("no subset of the points determines a curve")
+	}
+	return NewPolynomial(coeffs, points), nil
+}
+
+// theilSenKnots places the NJS knots at evenly spaced quantiles of the points, so they follow where plays are instead
+// of spreading over NJS nobody played, with at least as many distinct knots as the curve has terms if the points allow
+func theilSenKnots(points []plotter.XY, terms int) []plotter.XY {
+	xs := make([]float64, len(points))
+	for i, p := range points {
+		xs[i] = p.X
+	}
+	sort.Float64s(xs)
+
+	n := max(2*terms, theilSenMinKnots)
+	var knots []plotter.XY
+	for i := 0; i < n; i++ {
+		x := percentile(xs, 100*float64(i)/float64(n-1))
+		if len(knots) == 0 || x > knots[len(knots)-1].X {
+			knots = append(knots, plotter.XY{X: x})
+		}
+	}
+	return knots
+}
+
+// fitRANSAC fits exact curves through random subsets of degree+1 points, keeps the one most points agree with and
+// fits the final curve to those points by least squares. Agreeing is judged against the residual scale of the curve
+// with the smallest median residual, which unlike the one of a least squares fit isn't inflated by the outliers.
+// anchors can be drawn into the subsets and are always part of the final fit, however far they are from the curve.
+func fitRANSAC(points []plotter.XY, anchors []plotter.XY, degree int, rng *rand.Rand) (*Polynomial, error) {
+	all := append(slices.Clip(points), anchors...)
+	if len(all) <= degree+1 {
+		return nil, fmt.Errorf("too few points for a curve of degree %d", degree)
+	}
+
+	var candidates [][]float64
+	scale := math.Inf(1)
+	for s := 0; s < ransacSamples; s++ {
+		coeffs, ok := weightedPolyFit(sample(all, degree+1, rng), ones(degree+1), degree)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, coeffs)
+		scale = min(scale, residualScale(points, coeffs))
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no subset of the points determines a curve")
+	}
+	threshold := max(ransacThreshold*scale, 1e-3)
+
+	var best []plotter.XY
+	bestSSE := math.Inf(1)
+	for _, coeffs := range candidates {
+		inliers := slices.Clone(anchors)
+		sse := 0.0
+		for _, p := range points {
+			if r := math.Abs(p.Y - evalPoly(coeffs, p.X)); r <= threshold {
+				inliers = append(inliers, p)
+				sse += r * r
+			}
+		}
+		if len(inliers) > len(best) || (len(inliers) == len(best) && sse < bestSSE) {
+			best, bestSSE = inliers, sse
+		}
+	}
+	if len(best) <= degree+1 {
+		return nil, fmt.Errorf("too few points agree on a curve of degree %d", degree)
+	}
+
+	consensus, err := fitLeastSquares(best, degree)
+	if err != nil {
+		return nil, err
+	}
+	return NewPolynomial(consensus.Coeffs, all), nil
+}
+
+// ResidualInlierMask tells for every point whether its distance to the fitted curve is within k interquartile ranges
+// of all distances, so outliers are judged relative to the curve instead of the overall JD distribution
+func ResidualInlierMask(points []plotter.XY, model *Polynomial, k float64) []bool {
+	if len(points) == 0 {
+		return nil
+	}
+
+	residuals := make([]float64, len(points))
+	for i, p := range points {
+		residuals[i] = p.Y - model.Predict(p.X)
+	}
+	sorted := append([]float64(nil), residuals...)
+	sort.Float64s(sorted)
+
+	q1 := percentile(sorted, 25)
+	q3 := percentile(sorted, 75)
+	iqr := q3 - q1
+
+	mask := make([]bool, len(points))
+	for i, r := range residuals {
+		mask[i] = r >= q1-k*iqr && r <= q3+k*iqr
+	}
+	return mask
+}
+
+// residualScale estimates the standard deviation of the residuals by their median absolute deviation
+func residualScale(points []plotter.XY, coeffs []float64) float64 {
+	residuals := make([]float64, len(points))
+	for i, p := range points {
+		residuals[i] = math.Abs(p.Y - evalPoly(coeffs, p.X))
+	}
+	return median(residuals) / 0.6745
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return percentile(sorted, 50)
+}
+
+// sample draws n distinct points
+func sample(points []plotter.XY, n int, rng *rand.Rand) []plotter.XY {
+	res := make([]plotter.XY, n)
+	for i, j := range rng.Perm(len(points))[:n] {
+		res[i] = points[j]
+	}
+	return res
+}
+
+func ones(n int) []float64 {
+	res := make([]float64, n)
+	for i := range res {
+		res[i] = 1
+	}
+	return res
+}
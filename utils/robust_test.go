@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// withOutliers returns points on the polynomial with a little noise, where every fifth point lies shift above the curve
+func withOutliers(rng *rand.Rand, coeffs []float64, shift float64) []plotter.XY {
+	points := curve(coeffs, 8, 26, 0.5)
+	for i := range points {
+		points[i].Y += (rng.Float64()*2 - 1) * 0.1
+		if i%5 == 0 {
+			points[i].Y += shift
+		}
+	}
+	return points
+}
+
+// maxDeviation returns the largest distance of the fitted polynomial to the one with the given coefficients
+// on the usual NJS range
+func maxDeviation(model *Polynomial, coeffs []float64) float64 {
+	res := 0.0
+	for x := 8.0; x <= 26; x++ {
+		res = max(res, math.Abs(model.Predict(x)-evalPoly(coeffs, x)))
+	}
+	return res
+}
+
+func TestFitPolynomial(t *testing.T) {
+	line := []float64{10, 0.5}
+	quadratic := []float64{14, -0.4, 0.03}
+	cubic := []float64{6, 0.9, -0.04, 0.001}
+
+	tests := []struct {
+		name   string
+		method string
+		coeffs []float64
+		shift  float64
+		// maxDev is the largest deviation from the true curve allowed
+		maxDev float64
+	}{
+		{"ols line", FitOLS, line, 0, 0.1},
+		{"ols quadratic", FitOLS, quadratic, 0, 0.1},
+		{"huber line", FitHuber, line, 0, 0.1},
+		{"huber quadratic", FitHuber, quadratic, 0, 0.1},
+		{"huber line with outliers", FitHuber, line, 5, 0.3},
+		{"huber quadratic with outliers", FitHuber, quadratic, 5, 0.3},
+		{"theilsen line", FitTheilSen, line, 0, 0.1},
+		{"theilsen quadratic", FitTheilSen, quadratic, 0, 0.2},
+		{"theilsen line with outliers", FitTheilSen, line, 5, 0.2},
+		{"theilsen quadratic with outliers", FitTheilSen, quadratic, 5, 0.3},
+		// medians of single coefficients would miss the cubic by 0.4, they don't belong to the same curves
+		{"theilsen cubic", FitTheilSen, cubic, 0, 0.1},
+		{"theilsen cubic with outliers", FitTheilSen, cubic, 5, 0.2},
+		{"ransac line", FitRANSAC, line, 0, 0.1},
+		{"ransac quadratic", FitRANSAC, quadratic, 0, 0.1},
+		{"ransac line with outliers", FitRANSAC, line, 5, 0.1},
+		{"ransac quadratic with outliers", FitRANSAC, quadratic, 5, 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			points := withOutliers(rng, tt.coeffs, tt.shift)
+
+			model, err := FitPolynomial(points, nil, len(tt.coeffs)-1, tt.method, rng)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model.Degree() != len(tt.coeffs)-1 {
+				t.Errorf("FitPolynomial() degree = %d, want %d", model.Degree(), len(tt.coeffs)-1)
+			}
+			if dev := maxDeviation(model, tt.coeffs); dev > tt.maxDev {
+				t.Errorf("FitPolynomial() = %s, deviates %.3f from the true curve, want at most %.3f", model.Formula, dev, tt.maxDev)
+			}
+		})
+	}
+}
+
+func TestFitPolynomialOLSFollowsOutliers(t *testing.T) {
+	// the robust fits above are only worth something if least squares is pulled away by the same outliers
+	rng := rand.New(rand.NewSource(1))
+	coeffs := []float64{10, 0.5}
+
+	model, err := FitPolynomial(withOutliers(rng, coeffs, 5), nil, 1, FitOLS, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev := maxDeviation(model, coeffs); dev < 0.5 {
+		t.Errorf("FitPolynomial() = %s, deviates only %.3f from the true curve", model.Formula, dev)
+	}
+}
+
+func TestFitPolynomialInvalidMethod(t *testing.T) {
+	if _, err := FitPolynomial(curve([]float64{1, 1}, 0, 10, 1), nil, 1, "lasso", rand.New(rand.NewSource(1))); err == nil {
+		t.Error("FitPolynomial() accepted an invalid method")
+	}
+}
+
+func TestFitPolynomialRANSACKeepsAnchors(t *testing.T) {
+	// the origin anchor is 10 off the line of the plays, far more than any play, but must not be left out as outlier
+	rng := rand.New(rand.NewSource(1))
+	points := withOutliers(rng, []float64{10, 0.5}, 0)
+
+	without, err := FitPolynomial(points, nil, 1, FitRANSAC, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with, err := FitPolynomial(points, []plotter.XY{{X: 0, Y: 0}}, 1, FitRANSAC, rng)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// least squares on the plays and the anchor gives an intercept of about 7.7
+	if with.Predict(0) > without.Predict(0)-1 {
+		t.Errorf("FitPolynomial() = %s with the anchor and %s without, want the anchor to pull the curve down", with.Formula, without.Formula)
+	}
+}
+
+func TestResidualInlierMask(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	coeffs := []float64{10, 0.5}
+	points := withOutliers(rng, coeffs, 5)
+
+	model := NewPolynomial(coeffs, points)
+	for i, inlier := range ResidualInlierMask(points, model, 1.5) {
+		if want := i%5 != 0; inlier != want {
+			t.Errorf("ResidualInlierMask()[%d] = %v, want %v for %v", i, inlier, want, points[i])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Scalings applied to the features before clustering
+const (
+	ScaleNone   = "none"
+	ScaleZScore = "zscore"
+	ScaleRobust = "robust"
+)
+
+// Standardize scales every column of features so each one weighs the same in distances: zscore subtracts the mean and
+// divides by the standard deviation, robust subtracts the median and divides by the interquartile range, which keeps
+// a few extreme plays from squeezing everyone else together. NaN marks an unknown value, it is placed at the center.
+// Columns without any spread are only centered.
+func Standardize(features [][]float64, method string) ([][]float64, error) {
+	if method != ScaleNone && method != ScaleZScore && method != ScaleRobust {
+		return nil, fmt.Errorf("invalid scaling %q, expected %s, %s or %s", method, ScaleNone, ScaleZScore, ScaleRobust)
+	}
+	if len(features) == 0 {
+		return nil, nil
+	}
+
+	scaled := make([][]float64, len(features))
+	for i, row := range features {
+		scaled[i] = make([]float64, len(row))
+	}
+
+	for d := range features[0] {
+		var known []float64
+		for _, row := range features {
+			if !math.IsNaN(row[d]) {
+				known = append(known, row[d])
+			}
+		}
+
+		center, spread := columnScale(known, method)
+		if spread == 0 {
+			spread = 1
+		}
+		for i, row := range features {
+			if math.IsNaN(row[d]) {
+				continue
+			}
+			scaled[i][d] = (row[d] - center) / spread
+		}
+		if method == ScaleNone {
+			// unscaled columns have no center to fall back to, use the mean for unknown values
+			for i, row := range features {
+				if math.IsNaN(row[d]) {
+					scaled[i][d] = meanOf(known)
+				}
+			}
+		}
+	}
+	return scaled, nil
+}
+
+func columnScale(values []float64, method string) (center, spread float64) {
+	if len(values) == 0 {
+		return 0, 1
+	}
+	switch method {
+	case ScaleZScore:
+		center = meanOf(values)
+		for _, v := range values {
+			spread += (v - center) * (v - center)
+		}
+		return center, math.Sqrt(spread / float64(len(values)))
+	case ScaleRobust:
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		return percentile(sorted, 50), percentile(sorted, 75) - percentile(sorted, 25)
+	}
+	return 0, 1
+}
+
+func meanOf(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStandardize(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		name     string
+		method   string
+		features [][]float64
+		want     [][]float64
+	}{
+		{
+			name:     "none keeps values",
+			method:   ScaleNone,
+			features: [][]float64{{1, 10}, {2, 20}, {3, 30}},
+			want:     [][]float64{{1, 10}, {2, 20}, {3, 30}},
+		},
+		{
+			name:     "none fills unknown values with the mean",
+			method:   ScaleNone,
+			features: [][]float64{{1, 10}, {nan, 20}, {3, nan}},
+			want:     [][]float64{{1, 10}, {2, 20}, {3, 15}},
+		},
+		{
+			// mean 2 and 20, standard deviation sqrt(2/3) and 10*sqrt(2/3)
+			name:     "zscore",
+			method:   ScaleZScore,
+			features: [][]float64{{1, 10}, {2, 20}, {3, 30}},
+			want:     [][]float64{{-math.Sqrt(1.5), -math.Sqrt(1.5)}, {0, 0}, {math.Sqrt(1.5), math.Sqrt(1.5)}},
+		},
+		{
+			name:     "zscore centers unknown values",
+			method:   ScaleZScore,
+			features: [][]float64{{1, 10}, {3, nan}, {nan, 30}},
+			want:     [][]float64{{-1, -1}, {1, 0}, {0, 1}},
+		},
+		{
+			name:     "zscore only centers constant columns",
+			method:   ScaleZScore,
+			features: [][]float64{{1, 5}, {2, 5}, {3, 5}},
+			want:     [][]float64{{-math.Sqrt(1.5), 0}, {0, 0}, {math.Sqrt(1.5), 0}},
+		},
+		{
+			// median 3, interquartile range 4 - 2, the outlier stays far out instead of squeezing the rest
+			name:     "robust",
+			method:   ScaleRobust,
+			features: [][]float64{{1}, {2}, {3}, {4}, {100}},
+			want:     [][]float64{{-1}, {-0.5}, {0}, {0.5}, {48.5}},
+		},
+		{
+			name:     "robust centers unknown values",
+			method:   ScaleRobust,
+			features: [][]float64{{1}, {nan}, {3}, {5}},
+			want:     [][]float64{{-1}, {0}, {0}, {1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Standardize(tt.features, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.want {
+				for d := range tt.want[i] {
+					if math.Abs(got[i][d]-tt.want[i][d]) > 1e-9 {
+						t.Fatalf("Standardize() = %v, want %v", got, tt.want)
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := Standardize([][]float64{{1}}, "minmax"); err == nil {
+		t.Error("Standardize() accepted an invalid scaling")
+	}
+	if got, err := Standardize(nil, ScaleZScore); err != nil || got != nil {
+		t.Errorf("Standardize(nil) = %v, %v, want nil", got, err)
+	}
+}
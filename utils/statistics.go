@@ -4,11 +4,12 @@ import (
 	"math"
 	"sort"
 
-	"github.com/sajari/regression"
 	"gonum.org/v1/plot/plotter"
 )
 
-func RemoveOutliers(data []plotter.XY, k float64) []plotter.XY {
+// InlierMask tells for every data point whether its JD is within k interquartile ranges of all JDs, so data attached
+// to the points can be filtered alongside
+func InlierMask(data []plotter.XY, k float64) []bool {
 	if len(data) == 0 {
 		return nil
 	}
@@ -30,15 +31,11 @@ func RemoveOutliers(data []plotter.XY, k float64) []plotter.XY {
 	lowerBound := q1 - k*iqr
 	upperBound := q3 + k*iqr
 
-	// Filter the dataset
-	var filtered []plotter.XY
-	for _, dp := range data {
-		if dp.Y >= lowerBound && dp.Y <= upperBound {
-			filtered = append(filtered, dp)
-		}
+	mask := make([]bool, len(data))
+	for i, dp := range data {
+		mask[i] = dp.Y >= lowerBound && dp.Y <= upperBound
 	}
-
-	return filtered
+	return mask
 }
 
 // Helper function to compute the percentile of a sorted slice
@@ -56,16 +53,6 @@ func percentile(sorted []float64, p float64) float64 {
 	return sorted[lower]*(1-weight) + sorted[upper]*weight
 }
 
-func PredictDeg(reg *regression.Regression, deg int, value float64) (prediction float64, err error) {
-	x := make([]float64, deg)
-	for d := 1; d <= deg; d++ {
-		x[d-1] = math.Pow(value, float64(d))
-	}
-
-	prediction, err = reg.Predict(x)
-	return
-}
-
 func FindRange(points []plotter.XY, dim int) (float64, float64) {
 	lmin, lmax := math.MaxFloat64, -math.MaxFloat64
 	for _, p := range points {
@@ -83,9 +70,12 @@ func FindRange(points []plotter.XY, dim int) (float64, float64) {
 	return lmin, lmax
 }
 
-// distance calculates the Euclidean distance between two points
-func distance(p1, p2 plotter.XY) float64 {
-	dx := p1.X - p2.X
-	dy := p1.Y - p2.Y
-	return math.Sqrt(dx*dx + dy*dy)
+// distance calculates the Euclidean distance between two feature vectors
+func distance(p1, p2 []float64) float64 {
+	sum := 0.0
+	for i := range p1 {
+		d := p1[i] - p2[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum)
 }
@@ -2,9 +2,10 @@ package utils
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/sajari/regression"
 	"gonum.org/v1/plot/plotter"
 )
 
@@ -20,6 +21,8 @@ const (
 
 var (
 	BLDifficulties = []string{"Easy", "Normal", "Hard", "Expert", "ExpertPlus"}
+	// BLCharacteristics are the mode names BeatLeader uses in its urls
+	BLCharacteristics = []string{"Standard", "OneSaber", "NoArrows", "90Degree", "360Degree", "Lawless", "Legacy", "Lightshow"}
 )
 
 type (
@@ -96,7 +99,10 @@ type (
 	}
 	Cluster struct {
 		Points []plotter.XY
-		Model  *regression.Regression
+		Model  *Polynomial
+		Fit    ModelFit
+		// Group is the index of the group the points were clustered into
+		Group int
 	}
 
 	ALeaderboard struct {
@@ -265,7 +271,7 @@ type (
 		ValidForGeneral         bool          `json:"validForGeneral"`
 		ContextExtensions       []interface{} `json:"contextExtensions"`
 		LeaderboardId           string        `json:"leaderboardId"`
-		Leaderboard             interface{}   `json:"leaderboard"`
+		Leaderboard             *ALeaderboard `json:"leaderboard"`
 		AuthorizedReplayWatched int           `json:"authorizedReplayWatched"`
 		AnonimusReplayWatched   int           `json:"anonimusReplayWatched"`
 		ReplayWatchedTotal      int           `json:"replayWatchedTotal"`
@@ -438,12 +444,65 @@ type (
 		FirstSeen      time.Time   `json:"firstSeen"`
 	}
 
+	SSPlayersResponse struct {
+		Players  []SSPlayer  `json:"players"`
+		Metadata interface{} `json:"metadata"`
+	}
+
+	BLPlayer struct {
+		Id       string  `json:"id"`
+		Name     string  `json:"name"`
+		Platform string  `json:"platform"`
+		Country  string  `json:"country"`
+		Alias    string  `json:"alias"`
+		Pp       float64 `json:"pp"`
+		Rank     int     `json:"rank"`
+	}
+
+	BLPlayersResponse struct {
+		Metadata struct {
+			ItemsPerPage int `json:"itemsPerPage"`
+			Page         int `json:"page"`
+			Total        int `json:"total"`
+		} `json:"metadata"`
+		Data []BLPlayer `json:"data"`
+	}
+
 	StatsResult struct {
 		BLLead *BLLeaderboard `json:"blLeaderboard"`
+		Score  *BLScore       `json:"score"`
 		Stats  *ScoreStats    `json:"stats"`
 	}
 )
 
+// Time returns when the score was set, parsed from the unix timestamp in Timeset
+func (s *BLScore) Time() time.Time {
+	seconds, err := strconv.ParseInt(s.Timeset, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
+
+// AsPlayer converts a BeatLeader player to the player info used throughout the tool
+func (p *BLPlayer) AsPlayer() *SSPlayer {
+	return &SSPlayer{
+		Id:      p.Id,
+		Name:    p.Name,
+		Country: p.Country,
+		Pp:      p.Pp,
+		Rank:    p.Rank,
+	}
+}
+
 func (p JDPair) ToString() string {
 	return fmt.Sprintf("NJS:%f  JD:%f", p.NJS, p.JD)
 }
+
+// CharacteristicOf maps a ScoreSaber game mode like "SoloOneSaber" to the BeatLeader characteristic "OneSaber"
+func CharacteristicOf(gameMode string) string {
+	if gameMode == "" {
+		return "Standard"
+	}
+	return strings.TrimPrefix(gameMode, "Solo")
+}
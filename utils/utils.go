@@ -2,17 +2,13 @@ package utils
 
 import (
 	"bufio"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"math/rand"
-	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 )
 
@@ -23,10 +19,11 @@ func RequireNumbers(str string) bool {
 	return numbers.MatchString(str)
 }
 
+// GetInput asks q on stderr and reads the answer from stdin, stdout is left to the results
 func GetInput(q string) (string, error) {
 	reader := bufio.NewReader(os.Stdin)
 
-	fmt.Print(q)
+	_, _ = fmt.Fprint(os.Stderr, q)
 
 	key, err := reader.ReadString('\n')
 	if err != nil {
@@ -35,6 +32,24 @@ func GetInput(q string) (string, error) {
 	return strings.TrimSpace(key), nil
 }
 
+// IsInteractive reports whether stdin is attached to a terminal, so prompting the user makes sense
+func IsInteractive() bool {
+	return IsTerminal(os.Stdin)
+}
+
+// IsTerminal reports whether f is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device as well, but nobody is there to answer
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(fi, null) {
+		return false
+	}
+	return true
+}
+
 func RandomStr(n int) string {
 	b := make([]rune, n)
 	for i := range b {
@@ -43,37 +58,47 @@ func RandomStr(n int) string {
 	return string(b)
 }
 
-func OpenFile(dir string) {
-	if runtime.GOOS == "darwin" {
-		cmd := `open "` + dir + `"`
-		_ = exec.Command("/bin/bash", "-c", cmd).Start()
-	} else {
-		dir = strings.ReplaceAll(dir, "/", "\\")
-		_ = exec.Command("explorer", dir).Start()
-	}
-}
+// OpenFile opens path with the default application of the platform, without waiting for it to exit
+func OpenFile(path string) error {
+	var cmd *exec.Cmd
 
-func FetchToStruct[T any](url string) (*T, error) {
-	replayResp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	bytes, err := io.ReadAll(replayResp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if replayResp.StatusCode != 200 {
-		return nil, errors.New(strconv.Itoa(replayResp.StatusCode))
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("explorer", filepath.FromSlash(path))
+	default:
+		cmd = exec.Command("xdg-open", path)
 	}
 
-	var str T
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	// reap the process in the background, explorer exits with 1 even on success
+	go func() {
+		_ = cmd.Wait()
+	}()
+	return nil
+}
 
-	err = json.Unmarshal(bytes, &str)
-	if err != nil {
-		return nil, err
+// HasDisplay reports whether opened files can be shown to anyone, which is not the case in CI or over SSH
+func HasDisplay() bool {
+	if os.Getenv("CI") != "" {
+		return false
 	}
 
-	return &str, nil
+	switch runtime.GOOS {
+	case "windows":
+		return true
+	case "darwin":
+		return os.Getenv("SSH_CONNECTION") == ""
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return false
+		}
+		_, err := exec.LookPath("xdg-open")
+		return err == nil
+	}
 }
 
 func Map[T, V any](ts []T, fn func(T) V) []V {